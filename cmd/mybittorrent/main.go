@@ -3,17 +3,63 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
+	"strings"
 
 	"github.com/codecrafters-io/bittorrent-starter-go/torrent"
 )
 
 func main() {
-	torrentFilePath := flag.String("from", "", ".torrent file")
-	outputFileName := flag.String("to", "", "output file name")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "missing":
+			missing(os.Args[2:])
+
+			return
+		case "create":
+			create(os.Args[2:])
+
+			return
+		case "seed":
+			seed(os.Args[2:])
+
+			return
+		}
+	}
+
+	torrentFilePath := flag.String("from", "", ".torrent file, http(s) url, or - for standard input")
+	magnetURI := flag.String("magnet", "", "magnet link to download instead of -from")
+	outputFileName := flag.String("to", "", "output file name (parent directory for multi-file torrents)")
+	trackerListPath := flag.String("trackers", "", "file with extra announce urls, one per line")
+	infoOnly := flag.Bool("info", false, "print the torrent's details and exit without downloading")
 
 	flag.Parse()
 
-	client, err := torrent.NewTorrentClient(*torrentFilePath)
+	var opts []torrent.Option
+
+	if *trackerListPath != "" {
+		trackers, err := torrent.LoadTrackerList(*trackerListPath)
+
+		if err != nil {
+			fmt.Printf("failed to load trackers: %v\n", err)
+
+			return
+		}
+
+		opts = append(opts, torrent.WithExtraTrackers(trackers))
+	}
+
+	opts = append(opts, torrent.WithProgress(printProgress))
+
+	var client *torrent.TorrentClient
+
+	var err error
+
+	if *magnetURI != "" {
+		client, err = torrent.NewTorrentClientFromMagnet(*magnetURI, opts...)
+	} else {
+		client, err = openTorrent(*torrentFilePath, opts...)
+	}
 
 	if err != nil {
 		fmt.Printf("failed to init a client: %v\n", err)
@@ -21,11 +67,118 @@ func main() {
 		return
 	}
 
+	if *infoOnly {
+		if err := client.PrintInfo(os.Stdout); err != nil {
+			fmt.Printf("failed to print info: %v\n", err)
+		}
+
+		return
+	}
+
 	err = client.Download(*outputFileName)
 
+	fmt.Println()
+
 	if err != nil {
 		fmt.Printf("failed to download a file: %v\n", err)
 
 		return
 	}
 }
+
+// openTorrent loads a .torrent from a local path, an http(s) url or, for
+// "-", standard input.
+func openTorrent(from string, opts ...torrent.Option) (*torrent.TorrentClient, error) {
+	switch {
+	case from == "-":
+		return torrent.NewTorrentClientFromReader(os.Stdin, opts...)
+	case strings.HasPrefix(from, "http://"), strings.HasPrefix(from, "https://"):
+		return torrent.NewTorrentClientFromURL(from, opts...)
+	default:
+		return torrent.NewTorrentClient(from, opts...)
+	}
+}
+
+func printProgress(progress torrent.ProgressEvent) {
+	const width = 40
+
+	filled := width * progress.PiecesCompleted / max(progress.TotalPieces, 1)
+
+	fmt.Printf("\r[%s%s] %d/%d pieces, %d bytes",
+		strings.Repeat("#", filled), strings.Repeat(" ", width-filled),
+		progress.PiecesCompleted, progress.TotalPieces, progress.BytesDownloaded)
+}
+
+func missing(args []string) {
+	fs := flag.NewFlagSet("missing", flag.ExitOnError)
+
+	torrentFilePath := fs.String("from", "", ".torrent file")
+	fileName := fs.String("file", "", "local (possibly partial) file to check")
+
+	fs.Parse(args)
+
+	client, err := torrent.NewTorrentClient(*torrentFilePath)
+
+	if err != nil {
+		fmt.Printf("failed to init a client: %v\n", err)
+
+		return
+	}
+
+	pieces, missingBytes, err := client.MissingPieces(*fileName)
+
+	if err != nil {
+		fmt.Printf("failed to check a file: %v\n", err)
+
+		return
+	}
+
+	for _, index := range pieces {
+		fmt.Println(index)
+	}
+
+	fmt.Printf("%d pieces missing (%d bytes)\n", len(pieces), missingBytes)
+}
+
+func seed(args []string) {
+	fs := flag.NewFlagSet("seed", flag.ExitOnError)
+
+	torrentFilePath := fs.String("from", "", ".torrent file")
+	fileName := fs.String("file", "", "downloaded file to serve")
+	port := fs.Int("port", 6881, "port to listen on (0 picks a free one)")
+
+	fs.Parse(args)
+
+	client, err := torrent.NewTorrentClient(*torrentFilePath, torrent.WithPort(*port))
+
+	if err != nil {
+		fmt.Printf("failed to init a client: %v\n", err)
+
+		return
+	}
+
+	if err := client.Seed(*fileName); err != nil {
+		fmt.Printf("failed to seed a file: %v\n", err)
+
+		return
+	}
+}
+
+func create(args []string) {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+
+	sourcePath := fs.String("from", "", "file to create a torrent for")
+	outputPath := fs.String("to", "", "output .torrent file")
+	announce := fs.String("announce", "", "tracker announce url")
+	pieceLength := fs.Int64("piece-length", 256*1024, "piece length in bytes")
+
+	fs.Parse(args)
+
+	err := torrent.CreateTorrentFile(*sourcePath, *outputPath, *announce, *pieceLength)
+
+	if err != nil {
+		fmt.Printf("failed to create a torrent: %v\n", err)
+
+		return
+	}
+}
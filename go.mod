@@ -1,5 +1,3 @@
 module github.com/codecrafters-io/bittorrent-starter-go
 
 go 1.23
-
-require github.com/jackpal/bencode-go v1.0.0
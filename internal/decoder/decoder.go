@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"io"
 	"strconv"
 	"unicode"
 )
@@ -15,21 +16,81 @@ const (
 	End   = 'e'
 )
 
+// maxStringPrealloc bounds the buffer reserved up front for a string; longer
+// strings grow it as their bytes are read.
+const maxStringPrealloc = 64 * 1024
+
 type Decoder struct {
 	r *bufio.Reader
+
+	// offset counts the bytes consumed so far, for error reporting.
+	offset int64
+}
+
+// DecodeError reports malformed input along with the byte offset of the
+// value that could not be decoded.
+type DecodeError struct {
+	Offset int64
+	Msg    string
 }
 
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%s at offset %d", e.Msg, e.Offset)
+}
+
+func errorAt(offset int64, format string, args ...any) error {
+	return &DecodeError{Offset: offset, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (d *Decoder) readByte() (byte, error) {
+	b, err := d.r.ReadByte()
+
+	if err == nil {
+		d.offset++
+	}
+
+	return b, err
+}
+
+func (d *Decoder) unreadByte() error {
+	err := d.r.UnreadByte()
+
+	if err == nil {
+		d.offset--
+	}
+
+	return err
+}
+
+func (d *Decoder) readBytes(delim byte) ([]byte, error) {
+	b, err := d.r.ReadBytes(delim)
+	d.offset += int64(len(b))
+
+	return b, err
+}
+
+// New decodes an in-memory bencoded value.
 func New(bencoded []byte) *Decoder {
+	return NewReader(bytes.NewReader(bencoded))
+}
+
+// NewReader decodes straight from r, reading only as far as each Decode call
+// needs. A bufio.Reader is used as is rather than wrapped a second time.
+func NewReader(r io.Reader) *Decoder {
 	return &Decoder{
-		r: bufio.NewReader(bytes.NewReader(bencoded)),
+		r: bufio.NewReader(r),
 	}
 }
 
-func (d *Decoder) decodeInt() (int, error) {
-	intBytes, err := d.r.ReadBytes(End)
+// decodeInt returns an int when the value fits the platform int and an int64
+// otherwise, so large values are never truncated.
+func (d *Decoder) decodeInt() (any, error) {
+	start := d.offset - 1
+
+	intBytes, err := d.readBytes(End)
 
 	if err != nil {
-		return 0, fmt.Errorf("invalid int format")
+		return 0, errorAt(start, "invalid int format")
 	}
 
 	intBytes = intBytes[:len(intBytes)-1]
@@ -37,53 +98,72 @@ func (d *Decoder) decodeInt() (int, error) {
 	numStr := string(intBytes)
 
 	if len(numStr) > 1 && numStr[0] == '0' {
-		return 0, fmt.Errorf("invalid int format")
+		return 0, errorAt(start, "invalid int format")
 	}
 
-	n, err := strconv.Atoi(numStr)
+	n, err := strconv.ParseInt(numStr, 10, 64)
 
 	if err != nil {
-		return 0, fmt.Errorf("invalid int format")
+		return 0, errorAt(start, "invalid int format")
 	}
 
-	return n, nil
+	if strconv.IntSize < 64 && int64(int(n)) != n {
+		return n, nil
+	}
+
+	return int(n), nil
 }
 
 func (d *Decoder) decodeString(asBytes bool) (any, error) {
-	lenBytes, err := d.r.ReadBytes(':')
+	start := d.offset
+
+	lenBytes, err := d.readBytes(':')
 
 	if err != nil {
-		return nil, err
+		return nil, errorAt(start, "invalid string format")
 	}
 
 	lenBytes = lenBytes[:len(lenBytes)-1]
 
 	length, err := strconv.Atoi(string(lenBytes))
 
-	if err != nil {
-		return nil, fmt.Errorf("invalid string format")
+	if err != nil || length < 0 {
+		return nil, errorAt(start, "invalid string format")
 	}
 
-	str := make([]byte, length)
+	// The declared length comes from the input, which may be a remote peer,
+	// so memory only grows with the bytes that actually arrive.
+	var str bytes.Buffer
 
-	_, err = d.r.Read(str)
+	str.Grow(min(length, maxStringPrealloc))
+
+	n, err := io.CopyN(&str, d.r, int64(length))
+	d.offset += n
+
+	if err == io.EOF {
+		return nil, errorAt(start, "string declares %d bytes but input ends after %d", length, n)
+	}
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to read string: %v", err)
 	}
 
 	if asBytes {
-		return str, nil
+		return str.Bytes(), nil
 	}
 
-	return string(str), nil
+	return str.String(), nil
 }
 
 func (d *Decoder) decodeArray() ([]any, error) {
 	list := []any{}
 
 	for {
-		b, err := d.r.ReadByte()
+		b, err := d.readByte()
+
+		if err == io.EOF {
+			return nil, errorAt(d.offset, "unexpected end of input")
+		}
 
 		if err != nil {
 			return nil, fmt.Errorf("failed to read byte: %v", err)
@@ -93,7 +173,7 @@ func (d *Decoder) decodeArray() ([]any, error) {
 			break
 		}
 
-		err = d.r.UnreadByte()
+		err = d.unreadByte()
 
 		if err != nil {
 			return nil, fmt.Errorf("failed to unread byte: %v", err)
@@ -102,7 +182,7 @@ func (d *Decoder) decodeArray() ([]any, error) {
 		v, err := d.Decode()
 
 		if err != nil {
-			return nil, fmt.Errorf("failed to decode: %v", err)
+			return nil, fmt.Errorf("failed to decode: %w", err)
 		}
 
 		list = append(list, v)
@@ -115,7 +195,11 @@ func (d *Decoder) decodeDict() (map[string]any, error) {
 	m := make(map[string]any)
 
 	for {
-		b, err := d.r.ReadByte()
+		b, err := d.readByte()
+
+		if err == io.EOF {
+			return nil, errorAt(d.offset, "unexpected end of input")
+		}
 
 		if err != nil {
 			return nil, fmt.Errorf("failed to read byte: %v", err)
@@ -125,28 +209,30 @@ func (d *Decoder) decodeDict() (map[string]any, error) {
 			break
 		}
 
-		err = d.r.UnreadByte()
+		err = d.unreadByte()
 
 		if err != nil {
 			return nil, fmt.Errorf("failed to unread byte: %v", err)
 		}
 
+		keyOffset := d.offset
+
 		k, err := d.Decode()
 
 		if err != nil {
-			return nil, fmt.Errorf("failed to decode dict key: %v", err)
+			return nil, fmt.Errorf("failed to decode dict key: %w", err)
 		}
 
 		key, ok := k.(string)
 
 		if !ok {
-			return nil, fmt.Errorf("dict key must be a string (got %T instead)", key)
+			return nil, errorAt(keyOffset, "dict key must be a string (got %T instead)", k)
 		}
 
 		v, err := d.Decode()
 
 		if err != nil {
-			return nil, fmt.Errorf("failed to decode dict value: %v", err)
+			return nil, fmt.Errorf("failed to decode dict value: %w", err)
 		}
 
 		m[key] = v
@@ -156,7 +242,7 @@ func (d *Decoder) decodeDict() (map[string]any, error) {
 }
 
 func (d *Decoder) Decode() (any, error) {
-	b, err := d.r.ReadByte()
+	b, err := d.readByte()
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to read byte: %v", err)
@@ -164,7 +250,7 @@ func (d *Decoder) Decode() (any, error) {
 
 	switch {
 	case unicode.IsDigit(rune(b)):
-		err := d.r.UnreadByte()
+		err := d.unreadByte()
 
 		if err != nil {
 			return nil, fmt.Errorf("failed to unread byte: %v", err)
@@ -178,6 +264,26 @@ func (d *Decoder) Decode() (any, error) {
 	case b == Dict:
 		return d.decodeDict()
 	default:
-		return nil, fmt.Errorf("unknown format")
+		return nil, errorAt(d.offset-1, "unknown format")
+	}
+}
+
+// DecodeAll decodes concatenated values until the input ends. Running out of
+// input between values ends the stream; running out inside one is an error.
+func (d *Decoder) DecodeAll() ([]any, error) {
+	var values []any
+
+	for {
+		if _, err := d.r.Peek(1); err == io.EOF {
+			return values, nil
+		}
+
+		v, err := d.Decode()
+
+		if err != nil {
+			return values, err
+		}
+
+		values = append(values, v)
 	}
 }
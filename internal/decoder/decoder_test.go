@@ -0,0 +1,50 @@
+package decoder
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDecodeRejectsOversizedStringLength(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"beyond int range", "d1:m999999999999999999999:ae"},
+		{"beyond input", "d1:m999999999999999:ae"},
+		{"gigabytes", "2000000000:abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New([]byte(tt.input)).Decode()
+
+			var decodeErr *DecodeError
+
+			if !errors.As(err, &decodeErr) {
+				t.Fatalf("Decode(%q) error = %v, want a *DecodeError", tt.input, err)
+			}
+		})
+	}
+}
+
+func TestDecodeLongString(t *testing.T) {
+	long := strings.Repeat("x", 3*maxStringPrealloc+7)
+
+	got, err := New([]byte("196615:" + long)).Decode()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != long {
+		t.Fatalf("decoded %d bytes, want %d", len(got.(string)), len(long))
+	}
+}
+
+func TestSplitValueRejectsOversizedStringLength(t *testing.T) {
+	if _, _, err := SplitValue([]byte("9223372036854775807:ab")); err == nil {
+		t.Fatal("SplitValue accepted a string longer than its input")
+	}
+}
@@ -0,0 +1,115 @@
+package decoder
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// RawMessage is an already-encoded bencode value. Encode writes it verbatim,
+// which lets callers re-emit a dictionary byte for byte (e.g. to keep an info
+// hash stable).
+type RawMessage []byte
+
+// Encode serializes ints, strings, byte slices, lists and string-keyed
+// dictionaries to bencode. Dictionary keys are written in sorted order as the
+// spec requires.
+func Encode(v any) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if err := encode(&buf, v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func encode(buf *bytes.Buffer, v any) error {
+	switch value := v.(type) {
+	case RawMessage:
+		buf.Write(value)
+	case []byte:
+		encodeString(buf, string(value))
+	case string:
+		encodeString(buf, value)
+	case int:
+		encodeInt(buf, int64(value))
+	case int64:
+		encodeInt(buf, value)
+	case []any:
+		buf.WriteByte(Array)
+
+		for _, item := range value {
+			if err := encode(buf, item); err != nil {
+				return err
+			}
+		}
+
+		buf.WriteByte(End)
+	case map[string]any:
+		keys := make([]string, 0, len(value))
+
+		for k := range value {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+
+		buf.WriteByte(Dict)
+
+		for _, k := range keys {
+			encodeString(buf, k)
+
+			if err := encode(buf, value[k]); err != nil {
+				return fmt.Errorf("failed to encode dict value for %q: %v", k, err)
+			}
+		}
+
+		buf.WriteByte(End)
+	default:
+		return encodeReflect(buf, v)
+	}
+
+	return nil
+}
+
+// encodeReflect covers the remaining integer kinds and typed slices such as
+// []string or [][]string.
+func encodeReflect(buf *bytes.Buffer, v any) error {
+	rv := reflect.ValueOf(v)
+
+	switch rv.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		encodeInt(buf, rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		encodeInt(buf, int64(rv.Uint()))
+	case reflect.Slice, reflect.Array:
+		buf.WriteByte(Array)
+
+		for i := 0; i < rv.Len(); i++ {
+			if err := encode(buf, rv.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+
+		buf.WriteByte(End)
+	default:
+		return fmt.Errorf("cannot encode %T", v)
+	}
+
+	return nil
+}
+
+func encodeString(buf *bytes.Buffer, s string) {
+	buf.WriteString(strconv.Itoa(len(s)))
+	buf.WriteByte(':')
+	buf.WriteString(s)
+}
+
+func encodeInt(buf *bytes.Buffer, n int64) {
+	buf.WriteByte(Int)
+	buf.WriteString(strconv.FormatInt(n, 10))
+	buf.WriteByte(End)
+}
@@ -0,0 +1,71 @@
+package decoder
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name  string
+		value any
+		want  string
+	}{
+		{"string", "spam", "4:spam"},
+		{"bytes", []byte{0, 1}, "2:\x00\x01"},
+		{"int", 42, "i42e"},
+		{"negative int64", int64(-3), "i-3e"},
+		{"list", []any{"a", 1}, "l1:ai1ee"},
+		{"sorted dict", map[string]any{"b": 1, "a": "x"}, "d1:a1:x1:bi1ee"},
+		{"nested string lists", [][]string{{"a"}, {"b", "c"}}, "ll1:ael1:b1:cee"},
+		{"raw message", map[string]any{"info": RawMessage("d1:zi1e1:ai2ee")}, "d4:infod1:zi1e1:ai2eee"},
+	}
+
+	for _, tt := range tests {
+		got, err := Encode(tt.value)
+
+		if err != nil {
+			t.Errorf("%s: Encode() error = %v", tt.name, err)
+			continue
+		}
+
+		if string(got) != tt.want {
+			t.Errorf("%s: Encode() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	value := map[string]any{
+		"announce": "http://tracker/announce",
+		"info": map[string]any{
+			"length":       123,
+			"name":         "file",
+			"piece length": 16384,
+			"pieces":       string(make([]byte, 20)),
+		},
+		"list": []any{"x", 7, []any{}},
+	}
+
+	encoded, err := Encode(value)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := New(encoded).Decode()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(decoded, value) {
+		t.Fatalf("round trip = %#v, want %#v", decoded, value)
+	}
+}
+
+func TestEncodeRejectsUnsupportedValues(t *testing.T) {
+	if _, err := Encode(3.14); err == nil {
+		t.Fatal("Encode(float) succeeded")
+	}
+}
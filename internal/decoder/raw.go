@@ -0,0 +1,109 @@
+package decoder
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// RawValue returns the exact bytes of the value stored under key in the
+// top-level dictionary of bencoded, without re-encoding it.
+func RawValue(bencoded []byte, key string) ([]byte, error) {
+	if len(bencoded) == 0 || bencoded[0] != Dict {
+		return nil, fmt.Errorf("top-level value is not a dict")
+	}
+
+	pos := 1
+
+	for pos < len(bencoded) && bencoded[pos] != End {
+		keyEnd, err := skipValue(bencoded, pos)
+
+		if err != nil {
+			return nil, err
+		}
+
+		k, err := New(bencoded[pos:keyEnd]).Decode()
+
+		if err != nil {
+			return nil, err
+		}
+
+		valueEnd, err := skipValue(bencoded, keyEnd)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if k == key {
+			return bencoded[keyEnd:valueEnd], nil
+		}
+
+		pos = valueEnd
+	}
+
+	return nil, fmt.Errorf("key %q not found", key)
+}
+
+// skipValue returns the offset just past the value starting at pos.
+func skipValue(data []byte, pos int) (int, error) {
+	if pos >= len(data) {
+		return 0, fmt.Errorf("unexpected end of input at offset %d", pos)
+	}
+
+	switch b := data[pos]; {
+	case b >= '0' && b <= '9':
+		colon := bytes.IndexByte(data[pos:], ':')
+
+		if colon == -1 {
+			return 0, fmt.Errorf("invalid string format at offset %d", pos)
+		}
+
+		length, err := strconv.Atoi(string(data[pos : pos+colon]))
+
+		if err != nil || length < 0 || length > len(data)-pos-colon-1 {
+			return 0, fmt.Errorf("invalid string format at offset %d", pos)
+		}
+
+		return pos + colon + 1 + length, nil
+	case b == Int:
+		end := bytes.IndexByte(data[pos:], End)
+
+		if end == -1 {
+			return 0, fmt.Errorf("invalid int format at offset %d", pos)
+		}
+
+		return pos + end + 1, nil
+	case b == Array || b == Dict:
+		pos++
+
+		for pos < len(data) && data[pos] != End {
+			next, err := skipValue(data, pos)
+
+			if err != nil {
+				return 0, err
+			}
+
+			pos = next
+		}
+
+		if pos >= len(data) {
+			return 0, fmt.Errorf("unterminated %c at end of input", b)
+		}
+
+		return pos + 1, nil
+	default:
+		return 0, fmt.Errorf("unknown format at offset %d", pos)
+	}
+}
+
+// SplitValue separates the first bencoded value in data from whatever bytes
+// follow it.
+func SplitValue(data []byte) (value, rest []byte, err error) {
+	end, err := skipValue(data, 0)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return data[:end], data[end:], nil
+}
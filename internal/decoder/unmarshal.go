@@ -0,0 +1,182 @@
+package decoder
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Unmarshal decodes bencoded data into the value pointed to by v. Struct
+// fields are matched by their `bencode` tag, falling back to the field name.
+// Integers are range-checked against the destination type so a value that
+// doesn't fit is reported instead of being silently truncated.
+func Unmarshal(bencoded []byte, v any) error {
+	rv := reflect.ValueOf(v)
+
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("unmarshal target must be a non-nil pointer (got %T)", v)
+	}
+
+	decoded, err := New(bencoded).Decode()
+
+	if err != nil {
+		return err
+	}
+
+	return assign(rv.Elem(), decoded, "")
+}
+
+func assign(dst reflect.Value, src any, path string) error {
+	if dst.Kind() == reflect.Pointer {
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+
+		return assign(dst.Elem(), src, path)
+	}
+
+	if dst.Kind() == reflect.Interface && dst.NumMethod() == 0 {
+		dst.Set(reflect.ValueOf(src))
+		return nil
+	}
+
+	switch value := src.(type) {
+	case int:
+		return assignInt(dst, int64(value), path)
+	case int64:
+		return assignInt(dst, value, path)
+	case string:
+		return assignString(dst, value, path)
+	case []any:
+		return assignList(dst, value, path)
+	case map[string]any:
+		return assignDict(dst, value, path)
+	default:
+		return fmt.Errorf("%s: unsupported bencode value %T", fieldPath(path), src)
+	}
+}
+
+func assignInt(dst reflect.Value, n int64, path string) error {
+	switch dst.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if dst.OverflowInt(n) {
+			return fmt.Errorf("%s: integer %d overflows %s", fieldPath(path), n, dst.Type())
+		}
+
+		dst.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n < 0 || dst.OverflowUint(uint64(n)) {
+			return fmt.Errorf("%s: integer %d overflows %s", fieldPath(path), n, dst.Type())
+		}
+
+		dst.SetUint(uint64(n))
+	case reflect.Bool:
+		dst.SetBool(n != 0)
+	default:
+		return fmt.Errorf("%s: cannot assign integer to %s", fieldPath(path), dst.Type())
+	}
+
+	return nil
+}
+
+func assignString(dst reflect.Value, s string, path string) error {
+	switch {
+	case dst.Kind() == reflect.String:
+		dst.SetString(s)
+	case dst.Kind() == reflect.Slice && dst.Type().Elem().Kind() == reflect.Uint8:
+		dst.SetBytes([]byte(s))
+	default:
+		return fmt.Errorf("%s: cannot assign string to %s", fieldPath(path), dst.Type())
+	}
+
+	return nil
+}
+
+func assignList(dst reflect.Value, list []any, path string) error {
+	if dst.Kind() != reflect.Slice {
+		return fmt.Errorf("%s: cannot assign list to %s", fieldPath(path), dst.Type())
+	}
+
+	slice := reflect.MakeSlice(dst.Type(), len(list), len(list))
+
+	for i, item := range list {
+		if err := assign(slice.Index(i), item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+
+	dst.Set(slice)
+
+	return nil
+}
+
+func assignDict(dst reflect.Value, dict map[string]any, path string) error {
+	switch dst.Kind() {
+	case reflect.Map:
+		if dst.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("%s: map key must be a string (got %s)", fieldPath(path), dst.Type().Key())
+		}
+
+		if dst.IsNil() {
+			dst.Set(reflect.MakeMap(dst.Type()))
+		}
+
+		for k, v := range dict {
+			elem := reflect.New(dst.Type().Elem()).Elem()
+
+			if err := assign(elem, v, path+"."+k); err != nil {
+				return err
+			}
+
+			dst.SetMapIndex(reflect.ValueOf(k).Convert(dst.Type().Key()), elem)
+		}
+	case reflect.Struct:
+		t := dst.Type()
+
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+
+			if !field.IsExported() {
+				continue
+			}
+
+			key := fieldKey(field)
+
+			if key == "-" {
+				continue
+			}
+
+			v, ok := dict[key]
+
+			if !ok {
+				continue
+			}
+
+			if err := assign(dst.Field(i), v, path+"."+key); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%s: cannot assign dict to %s", fieldPath(path), dst.Type())
+	}
+
+	return nil
+}
+
+func fieldKey(field reflect.StructField) string {
+	tag := field.Tag.Get("bencode")
+
+	if name, _, _ := strings.Cut(tag, ","); name != "" {
+		return name
+	}
+
+	return field.Name
+}
+
+func fieldPath(path string) string {
+	if path == "" {
+		return "value"
+	}
+
+	return strings.TrimPrefix(path, ".")
+}
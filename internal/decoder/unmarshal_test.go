@@ -0,0 +1,61 @@
+package decoder
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnmarshalLargePieceLength(t *testing.T) {
+	// 8 GiB doesn't fit in 32 bits.
+	const input = "d12:piece lengthi8589934592ee"
+
+	var wide struct {
+		PieceLength int64 `bencode:"piece length"`
+	}
+
+	if err := Unmarshal([]byte(input), &wide); err != nil {
+		t.Fatal(err)
+	}
+
+	if wide.PieceLength != 8589934592 {
+		t.Fatalf("int64 field = %d, want 8589934592", wide.PieceLength)
+	}
+
+	var native struct {
+		PieceLength int `bencode:"piece length"`
+	}
+
+	if err := Unmarshal([]byte(input), &native); err != nil {
+		t.Fatal(err)
+	}
+
+	if native.PieceLength != 8589934592 {
+		t.Fatalf("int field = %d, want 8589934592", native.PieceLength)
+	}
+}
+
+func TestUnmarshalRejectsIntegerOverflow(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		target any
+	}{
+		{"int32", "d12:piece lengthi8589934592ee", &struct {
+			PieceLength int32 `bencode:"piece length"`
+		}{}},
+		{"uint16", "d4:porti70000ee", &struct {
+			Port uint16 `bencode:"port"`
+		}{}},
+		{"negative uint", "d4:porti-1ee", &struct {
+			Port uint `bencode:"port"`
+		}{}},
+	}
+
+	for _, tt := range tests {
+		err := Unmarshal([]byte(tt.input), tt.target)
+
+		if err == nil || !strings.Contains(err.Error(), "overflows") {
+			t.Errorf("%s: Unmarshal() = %v, want an overflow error", tt.name, err)
+		}
+	}
+}
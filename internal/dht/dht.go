@@ -0,0 +1,526 @@
+// Package dht implements enough of the mainline DHT (BEP 5) to look up peers
+// for an info hash. A node can run read-only (BEP 43), in which case it only
+// sends queries, or answer queries and store announced peers for others.
+package dht
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/decoder"
+)
+
+var DefaultBootstrap = []string{
+	"router.bittorrent.com:6881",
+	"dht.transmissionbt.com:6881",
+	"router.utorrent.com:6881",
+}
+
+const (
+	alpha          = 8
+	maxRounds      = 16
+	enoughPeers    = 50
+	queryTimeout   = 2 * time.Second
+	maxTableSize   = 512
+	maxStoredPeers = 256
+)
+
+var ErrClosed = errors.New("dht: node closed")
+
+type Config struct {
+	// Bootstrap nodes are queried first on every lookup, in order.
+	Bootstrap []string
+	// ReadOnly nodes don't answer queries or store peers for others.
+	ReadOnly bool
+	// Port is the UDP port to listen on; zero picks an ephemeral one.
+	Port int
+}
+
+type contact struct {
+	id   [20]byte
+	addr *net.UDPAddr
+}
+
+type Node struct {
+	cfg  Config
+	conn *net.UDPConn
+	id   [20]byte
+
+	secret [20]byte
+
+	mu      sync.Mutex
+	nextTID uint16
+	pending map[string]chan map[string]any
+	table   map[string]contact
+	stored  map[[20]byte][]string
+	closed  bool
+}
+
+func New(cfg Config) (*Node, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: cfg.Port})
+	if err != nil {
+		return nil, fmt.Errorf("dht: failed to listen: %v", err)
+	}
+
+	n := &Node{
+		cfg:     cfg,
+		conn:    conn,
+		pending: make(map[string]chan map[string]any),
+		table:   make(map[string]contact),
+		stored:  make(map[[20]byte][]string),
+	}
+
+	rand.Read(n.id[:])
+	rand.Read(n.secret[:])
+
+	go n.readLoop()
+
+	return n, nil
+}
+
+func (n *Node) Close() error {
+	n.mu.Lock()
+	n.closed = true
+	n.mu.Unlock()
+
+	return n.conn.Close()
+}
+
+func (n *Node) LocalAddr() net.Addr {
+	return n.conn.LocalAddr()
+}
+
+// AddNode adds a DHT node learned elsewhere (e.g. from a peer's port
+// message) to the contacts used by later lookups.
+func (n *Node) AddNode(addr string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return fmt.Errorf("dht: invalid node address: %v", err)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if len(n.table) < maxTableSize {
+		n.table[udpAddr.String()] = contact{addr: udpAddr}
+	}
+
+	return nil
+}
+
+func (n *Node) readLoop() {
+	buf := make([]byte, 64*1024)
+
+	for {
+		size, from, err := n.conn.ReadFromUDP(buf)
+		if err != nil {
+			n.mu.Lock()
+			for tid, ch := range n.pending {
+				close(ch)
+				delete(n.pending, tid)
+			}
+			n.mu.Unlock()
+
+			return
+		}
+
+		decoded, err := decoder.New(append([]byte(nil), buf[:size]...)).Decode()
+		if err != nil {
+			continue
+		}
+
+		msg, ok := decoded.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		tid, _ := msg["t"].(string)
+
+		switch msg["y"] {
+		case "r", "e":
+			n.mu.Lock()
+			ch, ok := n.pending[tid]
+			delete(n.pending, tid)
+			n.mu.Unlock()
+
+			if ok {
+				ch <- msg
+			}
+		case "q":
+			if !n.cfg.ReadOnly {
+				n.handleQuery(msg, from)
+			}
+		}
+	}
+}
+
+func (n *Node) send(addr *net.UDPAddr, msg map[string]any) error {
+	data, err := decoder.Encode(msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = n.conn.WriteToUDP(data, addr)
+
+	return err
+}
+
+// query sends a KRPC query and waits for its response.
+func (n *Node) query(addr *net.UDPAddr, method string, args map[string]any) (map[string]any, error) {
+	n.mu.Lock()
+
+	if n.closed {
+		n.mu.Unlock()
+		return nil, ErrClosed
+	}
+
+	n.nextTID++
+
+	tid := string([]byte{byte(n.nextTID >> 8), byte(n.nextTID)})
+	ch := make(chan map[string]any, 1)
+	n.pending[tid] = ch
+
+	n.mu.Unlock()
+
+	args["id"] = string(n.id[:])
+
+	msg := map[string]any{
+		"t": tid,
+		"y": "q",
+		"q": method,
+		"a": args,
+	}
+
+	if n.cfg.ReadOnly {
+		msg["ro"] = 1
+	}
+
+	if err := n.send(addr, msg); err != nil {
+		n.mu.Lock()
+		delete(n.pending, tid)
+		n.mu.Unlock()
+
+		return nil, fmt.Errorf("dht: failed to send %s: %v", method, err)
+	}
+
+	timer := time.NewTimer(queryTimeout)
+	defer timer.Stop()
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, ErrClosed
+		}
+
+		if resp["y"] == "e" {
+			return nil, fmt.Errorf("dht: %s returned error %v", method, resp["e"])
+		}
+
+		r, ok := resp["r"].(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("dht: malformed %s response", method)
+		}
+
+		n.remember(r, addr)
+
+		return r, nil
+	case <-timer.C:
+		n.mu.Lock()
+		delete(n.pending, tid)
+		n.mu.Unlock()
+
+		return nil, fmt.Errorf("dht: %s to %s timed out", method, addr)
+	}
+}
+
+// remember records a node that answered us so it can be handed out to others
+// and reused by later lookups.
+func (n *Node) remember(r map[string]any, addr *net.UDPAddr) {
+	id, ok := r["id"].(string)
+	if !ok || len(id) != 20 {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if _, known := n.table[addr.String()]; !known && len(n.table) >= maxTableSize {
+		return
+	}
+
+	c := contact{addr: addr}
+	copy(c.id[:], id)
+	n.table[addr.String()] = c
+}
+
+func distance(a, b [20]byte) [20]byte {
+	var d [20]byte
+
+	for i := range d {
+		d[i] = a[i] ^ b[i]
+	}
+
+	return d
+}
+
+func parseNodes(s string) []contact {
+	var contacts []contact
+
+	for i := 0; i+26 <= len(s); i += 26 {
+		c := contact{
+			addr: &net.UDPAddr{
+				IP:   net.IP([]byte(s[i+20 : i+24])),
+				Port: int(binary.BigEndian.Uint16([]byte(s[i+24 : i+26]))),
+			},
+		}
+
+		copy(c.id[:], s[i:i+20])
+
+		if c.addr.Port != 0 {
+			contacts = append(contacts, c)
+		}
+	}
+
+	return contacts
+}
+
+func compactNodes(contacts []contact) string {
+	var buf bytes.Buffer
+
+	for _, c := range contacts {
+		ip := c.addr.IP.To4()
+		if ip == nil || c.id == [20]byte{} {
+			continue
+		}
+
+		buf.Write(c.id[:])
+		buf.Write(ip)
+		binary.Write(&buf, binary.BigEndian, uint16(c.addr.Port))
+	}
+
+	return buf.String()
+}
+
+func parseValues(values []any) []string {
+	var peers []string
+
+	for _, v := range values {
+		s, ok := v.(string)
+		if !ok || len(s) != 6 {
+			continue
+		}
+
+		port := binary.BigEndian.Uint16([]byte(s[4:6]))
+		peers = append(peers, net.JoinHostPort(net.IP([]byte(s[:4])).String(), strconv.Itoa(int(port))))
+	}
+
+	return peers
+}
+
+// GetPeers runs an iterative get_peers lookup for infoHash, starting from the
+// bootstrap nodes and then the contacts closest to the info hash.
+func (n *Node) GetPeers(infoHash [20]byte, timeout time.Duration) ([]string, error) {
+	deadline := time.Now().Add(timeout)
+
+	var bootstrap []contact
+
+	for _, addr := range n.cfg.Bootstrap {
+		udpAddr, err := net.ResolveUDPAddr("udp", addr)
+		if err == nil {
+			bootstrap = append(bootstrap, contact{addr: udpAddr})
+		}
+	}
+
+	n.mu.Lock()
+	known := make([]contact, 0, len(n.table))
+	for _, c := range n.table {
+		known = append(known, c)
+	}
+	n.mu.Unlock()
+
+	queried := make(map[string]bool)
+	seenPeers := make(map[string]bool)
+
+	var peers []string
+
+	candidates := append(bootstrap, known...)
+
+	for round := 0; round < maxRounds && time.Now().Before(deadline); round++ {
+		var batch []contact
+
+		for _, c := range candidates {
+			if len(batch) == alpha {
+				break
+			}
+
+			if !queried[c.addr.String()] {
+				queried[c.addr.String()] = true
+				batch = append(batch, c)
+			}
+		}
+
+		if len(batch) == 0 {
+			break
+		}
+
+		type result struct {
+			nodes  []contact
+			values []string
+		}
+
+		results := make(chan result, len(batch))
+
+		for _, c := range batch {
+			go func(c contact) {
+				r, err := n.query(c.addr, "get_peers", map[string]any{
+					"info_hash": string(infoHash[:]),
+				})
+
+				if err != nil {
+					results <- result{}
+					return
+				}
+
+				nodes, _ := r["nodes"].(string)
+				values, _ := r["values"].([]any)
+
+				results <- result{nodes: parseNodes(nodes), values: parseValues(values)}
+			}(c)
+		}
+
+		for range batch {
+			res := <-results
+
+			for _, peer := range res.values {
+				if !seenPeers[peer] {
+					seenPeers[peer] = true
+					peers = append(peers, peer)
+				}
+			}
+
+			candidates = append(candidates, res.nodes...)
+		}
+
+		if len(peers) >= enoughPeers {
+			break
+		}
+
+		// Bootstrap nodes have gone first; from now on query whoever is
+		// closest to the info hash.
+		sort.SliceStable(candidates, func(i, j int) bool {
+			di, dj := distance(candidates[i].id, infoHash), distance(candidates[j].id, infoHash)
+			return bytes.Compare(di[:], dj[:]) < 0
+		})
+	}
+
+	if len(peers) == 0 {
+		return nil, fmt.Errorf("dht: no peers found")
+	}
+
+	return peers, nil
+}
+
+func (n *Node) token(ip net.IP) string {
+	sum := sha1.Sum(append(append([]byte(nil), n.secret[:]...), ip...))
+	return string(sum[:8])
+}
+
+func (n *Node) closest(target [20]byte, count int) []contact {
+	n.mu.Lock()
+	contacts := make([]contact, 0, len(n.table))
+	for _, c := range n.table {
+		contacts = append(contacts, c)
+	}
+	n.mu.Unlock()
+
+	sort.Slice(contacts, func(i, j int) bool {
+		di, dj := distance(contacts[i].id, target), distance(contacts[j].id, target)
+		return bytes.Compare(di[:], dj[:]) < 0
+	})
+
+	if len(contacts) > count {
+		contacts = contacts[:count]
+	}
+
+	return contacts
+}
+
+func (n *Node) handleQuery(msg map[string]any, from *net.UDPAddr) {
+	tid, _ := msg["t"].(string)
+	method, _ := msg["q"].(string)
+	args, _ := msg["a"].(map[string]any)
+
+	r := map[string]any{"id": string(n.id[:])}
+
+	var target [20]byte
+
+	switch method {
+	case "ping":
+	case "find_node":
+		t, _ := args["target"].(string)
+		copy(target[:], t)
+		r["nodes"] = compactNodes(n.closest(target, 8))
+	case "get_peers":
+		ih, _ := args["info_hash"].(string)
+		copy(target[:], ih)
+		r["token"] = n.token(from.IP)
+
+		n.mu.Lock()
+		stored := n.stored[target]
+		n.mu.Unlock()
+
+		if len(stored) > 0 {
+			var values []any
+
+			for _, peer := range stored {
+				host, portStr, _ := net.SplitHostPort(peer)
+				ip := net.ParseIP(host).To4()
+				port, _ := strconv.Atoi(portStr)
+
+				if ip != nil {
+					values = append(values, string(append(ip, byte(port>>8), byte(port))))
+				}
+			}
+
+			r["values"] = values
+		} else {
+			r["nodes"] = compactNodes(n.closest(target, 8))
+		}
+	case "announce_peer":
+		ih, _ := args["info_hash"].(string)
+		token, _ := args["token"].(string)
+		port, _ := args["port"].(int)
+
+		if implied, _ := args["implied_port"].(int); implied == 1 {
+			port = from.Port
+		}
+
+		if token != n.token(from.IP) || len(ih) != 20 {
+			n.send(from, map[string]any{"t": tid, "y": "e", "e": []any{203, "bad token"}})
+			return
+		}
+
+		copy(target[:], ih)
+		peer := net.JoinHostPort(from.IP.String(), strconv.Itoa(port))
+
+		n.mu.Lock()
+		if len(n.stored[target]) < maxStoredPeers {
+			n.stored[target] = append(n.stored[target], peer)
+		}
+		n.mu.Unlock()
+	default:
+		n.send(from, map[string]any{"t": tid, "y": "e", "e": []any{204, "method unknown"}})
+		return
+	}
+
+	n.send(from, map[string]any{"t": tid, "y": "r", "r": r})
+}
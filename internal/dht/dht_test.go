@@ -0,0 +1,160 @@
+package dht
+
+import (
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/decoder"
+)
+
+// queryLog records, in arrival order, which fake node received each query.
+type queryLog struct {
+	mu      sync.Mutex
+	entries []string
+	queries []map[string]any
+}
+
+func (l *queryLog) add(name string, msg map[string]any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = append(l.entries, name)
+	l.queries = append(l.queries, msg)
+}
+
+func (l *queryLog) snapshot() ([]string, []map[string]any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return slices.Clone(l.entries), slices.Clone(l.queries)
+}
+
+// fakeNode answers every get_peers query on a loopback UDP socket with one
+// peer, logging the query under name.
+func fakeNode(t *testing.T, name string, log *queryLog) string {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	id := string(make([]byte, 20))
+
+	go func() {
+		buf := make([]byte, 64*1024)
+
+		for {
+			size, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+
+			decoded, err := decoder.New(append([]byte(nil), buf[:size]...)).Decode()
+			if err != nil {
+				continue
+			}
+
+			msg, _ := decoded.(map[string]any)
+			log.add(name, msg)
+
+			resp, _ := decoder.Encode(map[string]any{
+				"t": msg["t"],
+				"y": "r",
+				"r": map[string]any{"id": id, "values": []any{string([]byte{192, 0, 2, 1, 0x1a, 0xe1})}},
+			})
+
+			conn.WriteToUDP(resp, from)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestGetPeersQueriesBootstrapFirst(t *testing.T) {
+	var log queryLog
+
+	// More bootstrap nodes than one round queries, so the known node can
+	// only be reached once every bootstrap node has been asked.
+	var bootstrap []string
+
+	for i := 0; i < alpha; i++ {
+		bootstrap = append(bootstrap, fakeNode(t, "bootstrap", &log))
+	}
+
+	node, err := New(Config{Bootstrap: bootstrap, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer node.Close()
+
+	if err := node.AddNode(fakeNode(t, "known", &log)); err != nil {
+		t.Fatal(err)
+	}
+
+	peers, err := node.GetPeers([20]byte{1}, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(peers, []string{"192.0.2.1:6881"}) {
+		t.Fatalf("GetPeers() = %v, want [192.0.2.1:6881]", peers)
+	}
+
+	entries, queries := log.snapshot()
+
+	if len(entries) != alpha+1 {
+		t.Fatalf("%d queries sent, want %d", len(entries), alpha+1)
+	}
+
+	for i, name := range entries[:alpha] {
+		if name != "bootstrap" {
+			t.Fatalf("query %d went to the %s node before every bootstrap node was asked", i, name)
+		}
+	}
+
+	for i, query := range queries {
+		if query["q"] != "get_peers" || query["ro"] != 1 {
+			t.Fatalf("query %d = %v, want a read-only get_peers", i, query)
+		}
+	}
+}
+
+func TestReadOnlyNodeIgnoresQueries(t *testing.T) {
+	tests := []struct {
+		name     string
+		readOnly bool
+	}{
+		{"read-only", true},
+		{"full", false},
+	}
+
+	for _, tt := range tests {
+		node, err := New(Config{ReadOnly: tt.readOnly})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		client, err := New(Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		target := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: node.LocalAddr().(*net.UDPAddr).Port}
+
+		_, err = client.query(target, "ping", map[string]any{})
+
+		if answered := err == nil; answered == tt.readOnly {
+			t.Errorf("%s: ping answered = %v, want %v", tt.name, answered, !tt.readOnly)
+		}
+
+		node.Close()
+		client.Close()
+	}
+}
@@ -0,0 +1,543 @@
+// Package utp implements the client side of the Micro Transport Protocol
+// (BEP 29) closely enough to carry a BitTorrent peer connection. It omits
+// LEDBAT congestion control and selective acks: the window is a fixed number
+// of in-flight packets and lost packets are retransmitted on a timer.
+package utp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	stData  = 0
+	stFin   = 1
+	stState = 2
+	stReset = 3
+	stSyn   = 4
+)
+
+const (
+	version    = 1
+	headerSize = 20
+
+	maxPayload       = 1200
+	maxInFlight      = 64
+	maxReorder       = 1024
+	recvWindow       = 1 << 20
+	retransmitAfter  = time.Second
+	maxTransmissions = 6
+	tickInterval     = 100 * time.Millisecond
+)
+
+var ErrReset = errors.New("utp: connection reset by peer")
+
+type header struct {
+	typ           byte
+	connID        uint16
+	timestamp     uint32
+	timestampDiff uint32
+	wndSize       uint32
+	seqNr         uint16
+	ackNr         uint16
+}
+
+func (h header) marshal(payload []byte) []byte {
+	buf := make([]byte, headerSize+len(payload))
+
+	buf[0] = h.typ<<4 | version
+	binary.BigEndian.PutUint16(buf[2:4], h.connID)
+	binary.BigEndian.PutUint32(buf[4:8], h.timestamp)
+	binary.BigEndian.PutUint32(buf[8:12], h.timestampDiff)
+	binary.BigEndian.PutUint32(buf[12:16], h.wndSize)
+	binary.BigEndian.PutUint16(buf[16:18], h.seqNr)
+	binary.BigEndian.PutUint16(buf[18:20], h.ackNr)
+	copy(buf[headerSize:], payload)
+
+	return buf
+}
+
+func parsePacket(b []byte) (header, []byte, error) {
+	if len(b) < headerSize {
+		return header{}, nil, fmt.Errorf("utp: packet too short (%d bytes)", len(b))
+	}
+
+	if b[0]&0x0f != version {
+		return header{}, nil, fmt.Errorf("utp: unsupported version %d", b[0]&0x0f)
+	}
+
+	h := header{
+		typ:           b[0] >> 4,
+		connID:        binary.BigEndian.Uint16(b[2:4]),
+		timestamp:     binary.BigEndian.Uint32(b[4:8]),
+		timestampDiff: binary.BigEndian.Uint32(b[8:12]),
+		wndSize:       binary.BigEndian.Uint32(b[12:16]),
+		seqNr:         binary.BigEndian.Uint16(b[16:18]),
+		ackNr:         binary.BigEndian.Uint16(b[18:20]),
+	}
+
+	payload := b[headerSize:]
+
+	// Skip the extension chain; we don't negotiate any extensions.
+	for ext := b[1]; ext != 0; {
+		if len(payload) < 2 || len(payload) < 2+int(payload[1]) {
+			return header{}, nil, fmt.Errorf("utp: truncated extension")
+		}
+
+		ext = payload[0]
+		payload = payload[2+int(payload[1]):]
+	}
+
+	return h, payload, nil
+}
+
+func seqLess(a, b uint16) bool {
+	return int16(a-b) < 0
+}
+
+func now() uint32 {
+	return uint32(time.Now().UnixMicro())
+}
+
+type outPacket struct {
+	typ           byte
+	seqNr         uint16
+	payload       []byte
+	sentAt        time.Time
+	transmissions int
+}
+
+// Conn is a uTP stream satisfying net.Conn.
+type Conn struct {
+	udp *net.UDPConn
+
+	mu      sync.Mutex
+	changed chan struct{}
+
+	sendID uint16
+	recvID uint16
+	seqNr  uint16
+	ackNr  uint16
+
+	inflight map[uint16]*outPacket
+	reorder  map[uint16][]byte
+	readBuf  bytes.Buffer
+
+	finReceived   bool
+	finSeq        uint16
+	finPending    bool
+	timestampDiff uint32
+
+	err    error
+	closed bool
+	done   chan struct{}
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+// DialTimeout opens a uTP connection to addr, giving up on the SYN exchange
+// after timeout.
+func DialTimeout(addr string, timeout time.Duration) (*Conn, error) {
+	raw, err := net.DialTimeout("udp", addr, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("utp: failed to dial: %v", err)
+	}
+
+	c := &Conn{
+		udp:      raw.(*net.UDPConn),
+		changed:  make(chan struct{}),
+		inflight: make(map[uint16]*outPacket),
+		reorder:  make(map[uint16][]byte),
+		done:     make(chan struct{}),
+	}
+
+	c.recvID = uint16(rand.Intn(1 << 16))
+	c.sendID = c.recvID + 1
+	c.seqNr = 1
+
+	if err := c.handshake(time.Now().Add(timeout)); err != nil {
+		c.udp.Close()
+		return nil, err
+	}
+
+	go c.readLoop()
+	go c.timerLoop()
+
+	return c, nil
+}
+
+func (c *Conn) handshake(deadline time.Time) error {
+	syn := header{
+		typ:     stSyn,
+		connID:  c.recvID,
+		wndSize: recvWindow,
+		seqNr:   c.seqNr,
+	}
+
+	c.seqNr++
+
+	buf := make([]byte, 64*1024)
+
+	for time.Now().Before(deadline) {
+		syn.timestamp = now()
+
+		if _, err := c.udp.Write(syn.marshal(nil)); err != nil {
+			return fmt.Errorf("utp: failed to send syn: %v", err)
+		}
+
+		wait := time.Now().Add(retransmitAfter)
+		if wait.After(deadline) {
+			wait = deadline
+		}
+
+		c.udp.SetReadDeadline(wait)
+
+		for {
+			n, err := c.udp.Read(buf)
+			if err != nil {
+				break
+			}
+
+			h, _, err := parsePacket(buf[:n])
+			if err != nil || h.connID != c.recvID {
+				continue
+			}
+
+			if h.typ == stReset {
+				return ErrReset
+			}
+
+			if h.typ == stState && h.ackNr == syn.seqNr {
+				c.ackNr = h.seqNr - 1
+				c.udp.SetReadDeadline(time.Time{})
+
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("utp: handshake timed out")
+}
+
+// broadcast wakes every goroutine waiting on a state change. Callers must
+// hold c.mu.
+func (c *Conn) broadcast() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// wait releases c.mu until the connection state changes or the deadline
+// passes. Callers must hold c.mu.
+func (c *Conn) wait(deadline time.Time) error {
+	changed := c.changed
+
+	c.mu.Unlock()
+	defer c.mu.Lock()
+
+	var expired <-chan time.Time
+
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+
+		expired = timer.C
+	}
+
+	select {
+	case <-changed:
+		return nil
+	case <-expired:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+func (c *Conn) fail(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+
+	c.broadcast()
+}
+
+func (c *Conn) readLoop() {
+	buf := make([]byte, 64*1024)
+
+	for {
+		n, err := c.udp.Read(buf)
+		if err != nil {
+			c.mu.Lock()
+			c.fail(fmt.Errorf("utp: read failed: %v", err))
+			c.mu.Unlock()
+
+			return
+		}
+
+		h, payload, err := parsePacket(buf[:n])
+		if err != nil || h.connID != c.recvID {
+			continue
+		}
+
+		c.mu.Lock()
+		c.handlePacket(h, payload)
+		c.mu.Unlock()
+	}
+}
+
+func (c *Conn) handlePacket(h header, payload []byte) {
+	c.timestampDiff = now() - h.timestamp
+
+	for seq := range c.inflight {
+		if !seqLess(h.ackNr, seq) {
+			delete(c.inflight, seq)
+		}
+	}
+
+	switch h.typ {
+	case stReset:
+		c.fail(ErrReset)
+		return
+	case stData:
+		c.receive(h.seqNr, payload)
+		c.sendState()
+	case stFin:
+		c.finPending = true
+		c.finSeq = h.seqNr
+		c.receive(h.seqNr, nil)
+		c.sendState()
+	}
+
+	c.broadcast()
+}
+
+func (c *Conn) receive(seq uint16, payload []byte) {
+	if seq != c.ackNr+1 {
+		if seqLess(c.ackNr, seq) && len(c.reorder) < maxReorder {
+			c.reorder[seq] = append([]byte(nil), payload...)
+		}
+
+		return
+	}
+
+	c.readBuf.Write(payload)
+	c.ackNr = seq
+
+	for {
+		if c.finPending && c.finSeq == c.ackNr {
+			c.finReceived = true
+		}
+
+		next, ok := c.reorder[c.ackNr+1]
+		if !ok {
+			break
+		}
+
+		delete(c.reorder, c.ackNr+1)
+		c.readBuf.Write(next)
+		c.ackNr++
+	}
+}
+
+func (c *Conn) sendState() {
+	wnd := recvWindow - c.readBuf.Len()
+	if wnd < 0 {
+		wnd = 0
+	}
+
+	state := header{
+		typ:           stState,
+		connID:        c.sendID,
+		timestamp:     now(),
+		timestampDiff: c.timestampDiff,
+		wndSize:       uint32(wnd),
+		seqNr:         c.seqNr,
+		ackNr:         c.ackNr,
+	}
+
+	c.udp.Write(state.marshal(nil))
+}
+
+func (c *Conn) transmit(p *outPacket) {
+	h := header{
+		typ:           p.typ,
+		connID:        c.sendID,
+		timestamp:     now(),
+		timestampDiff: c.timestampDiff,
+		wndSize:       recvWindow,
+		seqNr:         p.seqNr,
+		ackNr:         c.ackNr,
+	}
+
+	p.sentAt = time.Now()
+	p.transmissions++
+
+	c.udp.Write(h.marshal(p.payload))
+}
+
+func (c *Conn) timerLoop() {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		c.mu.Lock()
+
+		for _, p := range c.inflight {
+			if time.Since(p.sentAt) < retransmitAfter {
+				continue
+			}
+
+			if p.transmissions >= maxTransmissions {
+				c.fail(fmt.Errorf("utp: peer stopped acknowledging"))
+				break
+			}
+
+			c.transmit(p)
+		}
+
+		c.mu.Unlock()
+	}
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.readBuf.Len() == 0 {
+		if c.finReceived {
+			return 0, io.EOF
+		}
+
+		if c.err != nil {
+			return 0, c.err
+		}
+
+		if c.closed {
+			return 0, net.ErrClosed
+		}
+
+		if err := c.wait(c.readDeadline); err != nil {
+			return 0, err
+		}
+	}
+
+	n, _ := c.readBuf.Read(b)
+
+	return n, nil
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	written := 0
+
+	for written < len(b) {
+		if c.closed {
+			return written, net.ErrClosed
+		}
+
+		if c.err != nil {
+			return written, c.err
+		}
+
+		if len(c.inflight) >= maxInFlight {
+			if err := c.wait(c.writeDeadline); err != nil {
+				return written, err
+			}
+
+			continue
+		}
+
+		end := written + maxPayload
+		if end > len(b) {
+			end = len(b)
+		}
+
+		p := &outPacket{
+			typ:     stData,
+			seqNr:   c.seqNr,
+			payload: append([]byte(nil), b[written:end]...),
+		}
+
+		c.seqNr++
+		c.inflight[p.seqNr] = p
+		c.transmit(p)
+
+		written = end
+	}
+
+	return written, nil
+}
+
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+
+	if c.err == nil {
+		c.transmit(&outPacket{typ: stFin, seqNr: c.seqNr})
+		c.seqNr++
+	}
+
+	c.closed = true
+	close(c.done)
+	c.broadcast()
+
+	return c.udp.Close()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.udp.LocalAddr()
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.udp.RemoteAddr()
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.readDeadline = t
+	c.writeDeadline = t
+	c.broadcast()
+
+	return nil
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.readDeadline = t
+	c.broadcast()
+
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeDeadline = t
+	c.broadcast()
+
+	return nil
+}
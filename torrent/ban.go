@@ -0,0 +1,70 @@
+package torrent
+
+import (
+	"fmt"
+	"sync"
+)
+
+// banThreshold is how many failed pieces a peer may send before we stop
+// talking to it. A single bad piece can be a fluke; repeated ones rarely are.
+const banThreshold = 3
+
+// banList scores peers that sent pieces failing their hash check.
+type banList struct {
+	mu     sync.Mutex
+	scores map[string]int
+}
+
+// implicate records a failed piece from addr and reports whether that got it
+// banned.
+func (b *banList) implicate(addr string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.scores == nil {
+		b.scores = make(map[string]int)
+	}
+
+	b.scores[addr]++
+
+	return b.scores[addr] == banThreshold
+}
+
+func (b *banList) banned(addr string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.scores[addr] >= banThreshold
+}
+
+// BanScores returns how many corrupt pieces each peer has been blamed for.
+// Peers at or above the ban threshold are no longer connected to.
+func (client *TorrentClient) BanScores() map[string]int {
+	b := &client.bans
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	scores := make(map[string]int, len(b.scores))
+
+	for addr, score := range b.scores {
+		scores[addr] = score
+	}
+
+	return scores
+}
+
+// rejectPiece handles a piece that failed its hash check. Each piece is
+// fetched from a single peer, so that peer takes all the blame; it reports
+// whether the peer is now banned.
+func (client *TorrentClient) rejectPiece(peer *peerConn, index int, err error) bool {
+	client.emit(Event{Type: EventPieceFailed, Peer: peer.addr, Piece: index, Err: err})
+
+	if !client.bans.implicate(peer.addr) {
+		return false
+	}
+
+	client.emit(Event{Type: EventPeerBanned, Peer: peer.addr, Err: fmt.Errorf("sent %d corrupt pieces", banThreshold)})
+
+	return true
+}
@@ -0,0 +1,21 @@
+package torrent
+
+import "testing"
+
+func TestBanListBansAtThreshold(t *testing.T) {
+	var bans banList
+
+	for i := 1; i <= banThreshold+1; i++ {
+		if got, want := bans.implicate("192.0.2.1:6881"), i == banThreshold; got != want {
+			t.Fatalf("implicate() #%d = %v, want %v", i, got, want)
+		}
+
+		if got, want := bans.banned("192.0.2.1:6881"), i >= banThreshold; got != want {
+			t.Fatalf("banned() after %d failures = %v, want %v", i, got, want)
+		}
+	}
+
+	if bans.banned("192.0.2.2:6881") {
+		t.Fatal("an unrelated peer is banned")
+	}
+}
@@ -0,0 +1,90 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidBitfield = errors.New("invalid bitfield")
+
+type Bitfield []byte
+
+func NewBitfield(pieceCount int) Bitfield {
+	return make(Bitfield, (pieceCount+7)/8)
+}
+
+// fullBitfield returns a bitfield with every piece set and the spare bits of
+// the last byte left clear.
+func fullBitfield(pieceCount int) Bitfield {
+	bf := NewBitfield(pieceCount)
+
+	for i := 0; i < pieceCount; i++ {
+		bf.SetPiece(i)
+	}
+
+	return bf
+}
+
+func (bf Bitfield) HasPiece(index int) bool {
+	byteIndex := index / 8
+	offset := index % 8
+
+	if index < 0 || byteIndex >= len(bf) {
+		return false
+	}
+
+	return bf[byteIndex]>>(7-offset)&1 != 0
+}
+
+func (bf Bitfield) SetPiece(index int) {
+	byteIndex := index / 8
+	offset := index % 8
+
+	if index < 0 || byteIndex >= len(bf) {
+		return
+	}
+
+	bf[byteIndex] |= 1 << (7 - offset)
+}
+
+func (bf Bitfield) ClearPiece(index int) {
+	byteIndex := index / 8
+	offset := index % 8
+
+	if index < 0 || byteIndex >= len(bf) {
+		return
+	}
+
+	bf[byteIndex] &^= 1 << (7 - offset)
+}
+
+// without returns a copy of bf with the pieces set in other cleared.
+func (bf Bitfield) without(other Bitfield) Bitfield {
+	out := append(Bitfield(nil), bf...)
+
+	for i := range out {
+		if i < len(other) {
+			out[i] &^= other[i]
+		}
+	}
+
+	return out
+}
+
+// validate checks that a bitfield received from a peer has exactly one bit per
+// piece, rounded up to whole bytes, and that the spare trailing bits are clear.
+func (bf Bitfield) validate(pieceCount int) error {
+	expected := (pieceCount + 7) / 8
+
+	if len(bf) != expected {
+		return fmt.Errorf("%w: got %d bytes, expected %d", ErrInvalidBitfield, len(bf), expected)
+	}
+
+	if spare := expected*8 - pieceCount; spare > 0 {
+		if bf[expected-1]&(1<<spare-1) != 0 {
+			return fmt.Errorf("%w: spare bits are set", ErrInvalidBitfield)
+		}
+	}
+
+	return nil
+}
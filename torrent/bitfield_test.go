@@ -0,0 +1,101 @@
+package torrent
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestBitfieldPieces(t *testing.T) {
+	bf := NewBitfield(10)
+
+	bf.SetPiece(0)
+	bf.SetPiece(9)
+	bf.SetPiece(16) // past the last byte, ignored
+	bf.SetPiece(-1)
+
+	if want := (Bitfield{0x80, 0x40}); !bytes.Equal(bf, want) {
+		t.Fatalf("bitfield = %08b, want %08b", bf, want)
+	}
+
+	tests := []struct {
+		index int
+		want  bool
+	}{
+		{-1, false},
+		{0, true},
+		{1, false},
+		{9, true},
+		{100, false},
+	}
+
+	for _, tt := range tests {
+		if got := bf.HasPiece(tt.index); got != tt.want {
+			t.Errorf("HasPiece(%d) = %v, want %v", tt.index, got, tt.want)
+		}
+	}
+
+	bf.ClearPiece(9)
+
+	if bf.HasPiece(9) {
+		t.Fatal("piece 9 still set after ClearPiece")
+	}
+}
+
+func TestFullBitfield(t *testing.T) {
+	tests := []struct {
+		pieceCount int
+		want       Bitfield
+	}{
+		{0, Bitfield{}},
+		{3, Bitfield{0xe0}},
+		{8, Bitfield{0xff}},
+		{9, Bitfield{0xff, 0x80}},
+	}
+
+	for _, tt := range tests {
+		if got := fullBitfield(tt.pieceCount); !bytes.Equal(got, tt.want) {
+			t.Errorf("fullBitfield(%d) = %08b, want %08b", tt.pieceCount, got, tt.want)
+		}
+	}
+}
+
+func TestBitfieldValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		bf         Bitfield
+		pieceCount int
+		wantErr    bool
+	}{
+		{"exact", Bitfield{0xff}, 8, false},
+		{"spare bits clear", Bitfield{0xff, 0xc0}, 10, false},
+		{"spare bits set", Bitfield{0xff, 0xe0}, 10, true},
+		{"too short", Bitfield{0xff}, 10, true},
+		{"too long", Bitfield{0xff, 0, 0}, 10, true},
+	}
+
+	for _, tt := range tests {
+		err := tt.bf.validate(tt.pieceCount)
+
+		if tt.wantErr != (err != nil) {
+			t.Errorf("%s: validate() = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+
+		if err != nil && !errors.Is(err, ErrInvalidBitfield) {
+			t.Errorf("%s: validate() = %v, want ErrInvalidBitfield", tt.name, err)
+		}
+	}
+}
+
+func TestBitfieldWithout(t *testing.T) {
+	bf := Bitfield{0xff, 0xc0}
+	got := bf.without(Bitfield{0x0f})
+
+	if want := (Bitfield{0xf0, 0xc0}); !bytes.Equal(got, want) {
+		t.Fatalf("without() = %08b, want %08b", got, want)
+	}
+
+	if bf[0] != 0xff {
+		t.Fatal("without() modified its receiver")
+	}
+}
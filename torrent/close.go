@@ -0,0 +1,37 @@
+package torrent
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrClosed is returned by a download or seed interrupted by Close.
+var ErrClosed = errors.New("torrent client closed")
+
+// Close stops any running download, metadata fetch or seed, closing every
+// peer connection so the goroutines serving them return. It is safe to call
+// more than once.
+func (client *TorrentClient) Close() error {
+	client.shutdown(ErrClosed)
+
+	for _, peer := range client.peerManager.all() {
+		client.disconnectPeer(peer)
+	}
+
+	return nil
+}
+
+// untilClosed derives a context from ctx that is also cancelled, with cause
+// ErrClosed, when the client is closed.
+func (client *TorrentClient) untilClosed(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+
+	stop := context.AfterFunc(client.lifetime, func() {
+		cancel(ErrClosed)
+	})
+
+	return ctx, func() {
+		stop()
+		cancel(nil)
+	}
+}
@@ -0,0 +1,92 @@
+package torrent
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	defaultPort        = 6881
+	defaultDialTimeout = 10 * time.Second
+	defaultReadTimeout = 30 * time.Second
+	defaultMaxPeers    = 30
+	defaultBlockSize   = 16 * 1024
+	defaultDirMode     = 0755
+)
+
+// Config is an alternative to functional options for callers that load their
+// settings from a file or the environment. Zero values fall back to the
+// defaults used by NewTorrentClient.
+type Config struct {
+	Port int
+	// EphemeralPort lets Seed listen on a port the system picks, reported by
+	// Port, instead of the default. Port must be left zero.
+	EphemeralPort bool
+
+	PeerID      [20]byte
+	DialTimeout time.Duration
+	ReadTimeout time.Duration
+	MaxPeers    int
+	BlockSize   int
+
+	// PipelineDepth is the number of block requests kept outstanding to each
+	// peer.
+	PipelineDepth int
+
+	// Rate limits are in bytes per second; zero means unlimited.
+	DownloadRateLimit int64
+	UploadRateLimit   int64
+
+	// ResumeVerify is how much of a partial download is re-hashed before
+	// resuming; the zero value hashes every piece.
+	ResumeVerify ResumeVerify
+}
+
+func NewTorrentClientWithConfig(torrentFilePath string, cfg Config) (*TorrentClient, error) {
+	return NewTorrentClient(torrentFilePath, cfg.options()...)
+}
+
+func (cfg Config) options() []Option {
+	var opts []Option
+
+	switch {
+	case cfg.EphemeralPort && cfg.Port != 0:
+		opts = append(opts, func(client *TorrentClient) {
+			client.setOptionErr(fmt.Errorf("config sets both Port %d and EphemeralPort", cfg.Port))
+		})
+	case cfg.EphemeralPort:
+		opts = append(opts, WithPort(0))
+	case cfg.Port != 0:
+		opts = append(opts, WithPort(cfg.Port))
+	}
+
+	if cfg.PeerID != [20]byte{} {
+		opts = append(opts, WithPeerID(cfg.PeerID))
+	}
+
+	if cfg.DialTimeout != 0 || cfg.ReadTimeout != 0 {
+		opts = append(opts, WithTimeouts(cfg.DialTimeout, cfg.ReadTimeout))
+	}
+
+	if cfg.MaxPeers != 0 {
+		opts = append(opts, WithMaxPeers(cfg.MaxPeers))
+	}
+
+	if cfg.BlockSize != 0 {
+		opts = append(opts, WithBlockSize(cfg.BlockSize))
+	}
+
+	if cfg.PipelineDepth != 0 {
+		opts = append(opts, WithPipelineDepth(cfg.PipelineDepth))
+	}
+
+	if cfg.DownloadRateLimit != 0 || cfg.UploadRateLimit != 0 {
+		opts = append(opts, WithRateLimits(cfg.DownloadRateLimit, cfg.UploadRateLimit))
+	}
+
+	if cfg.ResumeVerify != ResumeVerifyFull {
+		opts = append(opts, WithResumeVerify(cfg.ResumeVerify))
+	}
+
+	return opts
+}
@@ -0,0 +1,150 @@
+package torrent
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestInvalidPortIsRejected(t *testing.T) {
+	path := writeTestTorrent(t, testTorrent(testData(100), 64), "http://tracker.invalid/announce")
+
+	tests := []struct {
+		name string
+		open func() error
+	}{
+		{"option above range", func() error {
+			_, err := NewTorrentClient(path, WithPort(70000))
+			return err
+		}},
+		{"option negative", func() error {
+			_, err := NewTorrentClient(path, WithPort(-1))
+			return err
+		}},
+		{"config above range", func() error {
+			_, err := NewTorrentClientWithConfig(path, Config{Port: 65536})
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.open(); !errors.Is(err, ErrInvalidPort) {
+				t.Fatalf("error = %v, want ErrInvalidPort", err)
+			}
+		})
+	}
+
+	if _, err := NewTorrentClientWithConfig(path, Config{Port: 7000, EphemeralPort: true}); err == nil {
+		t.Fatal("accepted a config with both Port and EphemeralPort")
+	}
+}
+
+func TestSeedReportsEphemeralPort(t *testing.T) {
+	data := testData(100)
+
+	dir := t.TempDir()
+	output := filepath.Join(dir, "test")
+
+	if err := os.WriteFile(output, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	path := writeTestTorrent(t, testTorrent(data, 64), "http://tracker.invalid/announce")
+
+	client, err := NewTorrentClientWithConfig(path, Config{EphemeralPort: true})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if client.Port() != 0 {
+		t.Fatalf("Port() = %d before seeding, want 0", client.Port())
+	}
+
+	done := make(chan error, 1)
+
+	go func() { done <- client.Seed(output) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for client.Port() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if client.Port() == 0 {
+		t.Fatal("Seed never reported the port it listens on")
+	}
+
+	client.Close()
+
+	if err := <-done; !errors.Is(err, ErrClosed) {
+		t.Fatalf("Seed() error = %v, want ErrClosed", err)
+	}
+}
+
+func TestConfigResumeVerify(t *testing.T) {
+	path := writeTestTorrent(t, testTorrent(testData(100), 64), "http://tracker.invalid/announce")
+
+	for _, mode := range []ResumeVerify{ResumeVerifyFull, ResumeVerifySampled, ResumeVerifyNone} {
+		client, err := NewTorrentClientWithConfig(path, Config{ResumeVerify: mode})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if client.resumeVerify != mode {
+			t.Errorf("resumeVerify = %d, want %d", client.resumeVerify, mode)
+		}
+
+		client.Close()
+	}
+}
+
+func TestZeroConfigMatchesDefaults(t *testing.T) {
+	path := writeTestTorrent(t, testTorrent(testData(100), 64), "http://tracker.invalid/announce")
+
+	defaults, err := NewTorrentClient(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer defaults.Close()
+
+	configured, err := NewTorrentClientWithConfig(path, Config{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer configured.Close()
+
+	settings := func(client *TorrentClient) map[string]any {
+		return map[string]any{
+			"port":                client.Port(),
+			"dial timeout":        client.dialTimeout,
+			"read timeout":        client.readTimeout,
+			"max peers":           client.maxPeers,
+			"block size":          client.blockSize,
+			"pipeline depth":      client.pipelineDepth,
+			"download rate limit": client.DownloadRateLimit,
+			"upload rate limit":   client.UploadRateLimit,
+			"resume verify":       client.resumeVerify,
+		}
+	}
+
+	got, want := settings(configured), settings(defaults)
+
+	for name := range want {
+		if got[name] != want[name] {
+			t.Errorf("%s = %v with a zero Config, want the default %v", name, got[name], want[name])
+		}
+	}
+
+	if configured.PeerID == [20]byte{} {
+		t.Error("zero Config left the peer id unset")
+	}
+}
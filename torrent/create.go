@@ -0,0 +1,156 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const checkpointEvery = 64
+
+// createCheckpoint records how far hashing got so an interrupted create can
+// pick up where it stopped. Size and ModTime guard against the source file
+// having changed in between.
+type createCheckpoint struct {
+	Source      string    `json:"source"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mod_time"`
+	PieceLength int64     `json:"piece_length"`
+	Pieces      int       `json:"pieces"`
+	Offset      int64     `json:"offset"`
+	Hashes      []byte    `json:"hashes"`
+}
+
+func checkpointPath(outputPath string) string {
+	return outputPath + ".create-state"
+}
+
+func loadCheckpoint(path string, info os.FileInfo, source string, pieceLength int64) (*createCheckpoint, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	var cp createCheckpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, false
+	}
+
+	if cp.Source != source || cp.Size != info.Size() || !cp.ModTime.Equal(info.ModTime()) || cp.PieceLength != pieceLength {
+		return nil, false
+	}
+
+	if len(cp.Hashes) != cp.Pieces*20 || cp.Offset != int64(cp.Pieces)*pieceLength {
+		return nil, false
+	}
+
+	return &cp, true
+}
+
+func saveCheckpoint(path string, cp *createCheckpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// CreateTorrentFile hashes sourcePath and writes a single-file .torrent to
+// outputPath. Progress is checkpointed next to the output, so calling it again
+// after an interruption resumes hashing instead of starting over, as long as
+// the source file is unchanged.
+func CreateTorrentFile(sourcePath, outputPath, announce string, pieceLength int64) error {
+	if pieceLength <= 0 {
+		return fmt.Errorf("piece length must be positive (got %d)", pieceLength)
+	}
+
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to open source file: %v", err)
+	}
+	defer source.Close()
+
+	info, err := source.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat source file: %v", err)
+	}
+
+	statePath := checkpointPath(outputPath)
+
+	cp, ok := loadCheckpoint(statePath, info, sourcePath, pieceLength)
+	if !ok {
+		cp = &createCheckpoint{
+			Source:      sourcePath,
+			Size:        info.Size(),
+			ModTime:     info.ModTime(),
+			PieceLength: pieceLength,
+		}
+	}
+
+	if _, err := source.Seek(cp.Offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek source file: %v", err)
+	}
+
+	buf := make([]byte, pieceLength)
+
+	for {
+		n, err := io.ReadFull(source, buf)
+
+		if n > 0 {
+			hash := sha1.Sum(buf[:n])
+
+			cp.Hashes = append(cp.Hashes, hash[:]...)
+			cp.Pieces++
+			cp.Offset += int64(n)
+
+			if cp.Pieces%checkpointEvery == 0 {
+				if err := saveCheckpoint(statePath, cp); err != nil {
+					return fmt.Errorf("failed to save create checkpoint: %v", err)
+				}
+			}
+		}
+
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to read source file: %v", err)
+		}
+	}
+
+	torrentFile := TorrentFile{
+		Announce: announce,
+		Info: MetaInfo{
+			Name:        filepath.Base(sourcePath),
+			Pieces:      string(cp.Hashes),
+			Length:      int(info.Size()),
+			PieceLength: pieceLength,
+		},
+	}
+
+	encoded, err := torrentFile.Encode()
+	if err != nil {
+		return fmt.Errorf("failed to encode torrent file: %v", err)
+	}
+
+	if err := os.WriteFile(outputPath, encoded, 0644); err != nil {
+		return fmt.Errorf("failed to write torrent file: %v", err)
+	}
+
+	os.Remove(statePath)
+
+	return nil
+}
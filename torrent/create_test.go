@@ -0,0 +1,141 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateTorrentFileResumesFromCheckpoint(t *testing.T) {
+	const pieceLength = 1024
+
+	dir := t.TempDir()
+	data := testData(100*pieceLength + 10)
+
+	source := filepath.Join(dir, "source")
+
+	if err := os.WriteFile(source, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	uninterrupted := filepath.Join(dir, "full.torrent")
+
+	if err := CreateTorrentFile(source, uninterrupted, "http://tracker/announce", pieceLength); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(source)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Leave the checkpoint an interrupted run would have saved after its
+	// first batch of pieces.
+	checkpoint := func(output string, hashes []byte) {
+		t.Helper()
+
+		cp := &createCheckpoint{
+			Source:      source,
+			Size:        info.Size(),
+			ModTime:     info.ModTime(),
+			PieceLength: pieceLength,
+			Pieces:      checkpointEvery,
+			Offset:      checkpointEvery * pieceLength,
+			Hashes:      hashes,
+		}
+
+		if err := saveCheckpoint(checkpointPath(output), cp); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var hashes []byte
+
+	for i := 0; i < checkpointEvery; i++ {
+		hash := sha1.Sum(data[i*pieceLength : (i+1)*pieceLength])
+		hashes = append(hashes, hash[:]...)
+	}
+
+	resumed := filepath.Join(dir, "resumed.torrent")
+	checkpoint(resumed, hashes)
+
+	if err := CreateTorrentFile(source, resumed, "http://tracker/announce", pieceLength); err != nil {
+		t.Fatal(err)
+	}
+
+	want, _ := os.ReadFile(uninterrupted)
+	got, _ := os.ReadFile(resumed)
+
+	if !bytes.Equal(got, want) {
+		t.Fatal("resumed create produced a different torrent")
+	}
+
+	if _, err := os.Stat(checkpointPath(resumed)); !os.IsNotExist(err) {
+		t.Fatalf("checkpoint left behind after create finished: %v", err)
+	}
+
+	// Hashes from the checkpoint are taken as they are rather than
+	// recomputed, which shows hashing picked up at the saved offset.
+	marked := filepath.Join(dir, "marked.torrent")
+	checkpoint(marked, bytes.Repeat([]byte{0xaa}, checkpointEvery*20))
+
+	if err := CreateTorrentFile(source, marked, "http://tracker/announce", pieceLength); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewTorrentClient(marked)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hash, _ := client.File.Info.PieceHash(0); hash[0] != 0xaa {
+		t.Fatalf("piece 0 hash = %x, want the checkpointed hash", hash)
+	}
+}
+
+func TestCreateTorrentFileIgnoresStaleCheckpoint(t *testing.T) {
+	const pieceLength = 1024
+
+	dir := t.TempDir()
+	data := testData(100 * pieceLength)
+
+	source := filepath.Join(dir, "source")
+
+	if err := os.WriteFile(source, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(dir, "out.torrent")
+
+	// A checkpoint for a different version of the source.
+	cp := &createCheckpoint{
+		Source:      source,
+		Size:        int64(len(data)) + 1,
+		PieceLength: pieceLength,
+		Pieces:      checkpointEvery,
+		Offset:      checkpointEvery * pieceLength,
+		Hashes:      bytes.Repeat([]byte{0xaa}, checkpointEvery*20),
+	}
+
+	if err := saveCheckpoint(checkpointPath(output), cp); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CreateTorrentFile(source, output, "http://tracker/announce", pieceLength); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewTorrentClient(output)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hash, _ := client.File.Info.PieceHash(0); hash != sha1.Sum(data[:pieceLength]) {
+		t.Fatalf("piece 0 hash = %x, want it rehashed from the source", hash)
+	}
+}
@@ -0,0 +1,83 @@
+package torrent
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/dht"
+)
+
+const (
+	dhtLookupTimeout = 30 * time.Second
+
+	// maxDHTNodes bounds the nodes kept from peers' port messages.
+	maxDHTNodes = 64
+)
+
+// dhtPeers looks the torrent up in the mainline DHT. Private torrents must
+// only use their trackers, and an empty bootstrap list turns the DHT off.
+func (client *TorrentClient) dhtPeers() ([]string, error) {
+	if client.IsPrivate() || len(client.dhtBootstrap) == 0 {
+		return nil, fmt.Errorf("dht disabled")
+	}
+
+	node, err := dht.New(dht.Config{
+		Bootstrap: client.dhtBootstrap,
+		ReadOnly:  client.dhtReadOnly,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer node.Close()
+
+	client.dhtNodesMu.Lock()
+	known := client.dhtNodes
+	client.dhtNodesMu.Unlock()
+
+	for _, addr := range known {
+		node.AddNode(addr)
+	}
+
+	return node.GetPeers(client.InfoHash, dhtLookupTimeout)
+}
+
+// addDHTNode handles a peer's port message: the peer's IP with the advertised
+// UDP port is a DHT node later lookups can start from. The message is ignored
+// when the DHT is disabled.
+func (client *TorrentClient) addDHTNode(peer *peerConn, msg *PeerMessage) error {
+	if len(msg.Payload) != 2 {
+		return fmt.Errorf("%w: port message with %d byte payload", ErrDesync, len(msg.Payload))
+	}
+
+	if client.IsPrivate() || len(client.dhtBootstrap) == 0 {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(peer.addr)
+
+	if err != nil {
+		return nil
+	}
+
+	port := binary.BigEndian.Uint16(msg.Payload)
+
+	if port == 0 {
+		return nil
+	}
+
+	addr := net.JoinHostPort(host, strconv.Itoa(int(port)))
+
+	client.dhtNodesMu.Lock()
+	defer client.dhtNodesMu.Unlock()
+
+	if len(client.dhtNodes) < maxDHTNodes {
+		client.dhtNodes = mergeUnique(client.dhtNodes, []string{addr})
+	}
+
+	return nil
+}
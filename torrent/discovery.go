@@ -0,0 +1,50 @@
+package torrent
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultDiscoveryInterval = 15 * time.Second
+	defaultDiscoveryTimeout  = 2 * time.Minute
+)
+
+// discoverPeers queries the trackers, then the DHT, until at least one peer turns up.
+// Cold torrents often have no peers on the first try, so instead of failing
+// straight away it re-queries every discoveryInterval until discoveryTimeout
+// has passed.
+func (client *TorrentClient) discoverPeers(ctx context.Context) error {
+	deadline := time.Now().Add(client.discoveryTimeout)
+
+	for attempt := 1; ; attempt++ {
+		err := client.connectTracker(ctx)
+
+		if err == nil && len(client.knownPeers()) > 0 {
+			return nil
+		}
+
+		if peers, dhtErr := client.dhtPeers(); dhtErr == nil {
+			client.addPeers(peers)
+
+			return nil
+		}
+
+		if err == nil {
+			err = fmt.Errorf("no peers found")
+		}
+
+		if !time.Now().Add(client.discoveryInterval).Before(deadline) {
+			return fmt.Errorf("failed to find peers after %d attempts: %v", attempt, err)
+		}
+
+		client.emit(Event{Type: EventWaitingForPeers, Err: err})
+
+		select {
+		case <-time.After(client.discoveryInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package torrent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/decoder"
+)
+
+// coldTracker lists a peer only from the given announce on.
+func coldTracker(t *testing.T, from int32) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var announces atomic.Int32
+
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peers := ""
+
+		if announces.Add(1) >= from {
+			peers = string([]byte{192, 0, 2, 1, 0x1a, 0xe1})
+		}
+
+		resp, _ := decoder.Encode(map[string]any{"interval": 60, "peers": peers})
+		w.Write(resp)
+	}))
+
+	t.Cleanup(tracker.Close)
+
+	return tracker, &announces
+}
+
+func TestDiscoverPeersWaitsForColdTorrent(t *testing.T) {
+	tracker, announces := coldTracker(t, 3)
+
+	path := writeTestTorrent(t, testTorrent(testData(1024), 1024), tracker.URL+"/announce")
+
+	client, err := NewTorrentClient(path, WithDHTBootstrap(nil), WithPeerDiscovery(10*time.Millisecond, 5*time.Second), WithEventDelivery(16, true))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := client.Events()
+
+	if err := client.discoverPeers(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := announces.Load(); got != 3 {
+		t.Fatalf("found peers after %d announces, want 3", got)
+	}
+
+	if len(client.Peers) != 1 {
+		t.Fatalf("peers = %v, want one", client.Peers)
+	}
+
+	waiting := 0
+
+	for len(events) > 0 {
+		if event := <-events; event.Type == EventWaitingForPeers {
+			waiting++
+		}
+	}
+
+	if waiting != 2 {
+		t.Fatalf("%d waiting for peers events, want 2", waiting)
+	}
+}
+
+func TestDiscoverPeersGivesUpAtDeadline(t *testing.T) {
+	tracker, _ := coldTracker(t, 1000)
+
+	path := writeTestTorrent(t, testTorrent(testData(1024), 1024), tracker.URL+"/announce")
+
+	client, err := NewTorrentClient(path, WithDHTBootstrap(nil), WithPeerDiscovery(10*time.Millisecond, 50*time.Millisecond))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.discoverPeers(context.Background())
+
+	if err == nil || !strings.Contains(err.Error(), "failed to find peers") {
+		t.Fatalf("discoverPeers() = %v, want a failure after the deadline", err)
+	}
+}
+
+func TestPrivateTorrentSkipsPeerDiscovery(t *testing.T) {
+	pex, err := decoder.Encode(map[string]any{"added": string([]byte{192, 0, 2, 7, 0x1a, 0xe1})})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		private bool
+	}{
+		{"public", false},
+		{"private", true},
+	}
+
+	for _, tt := range tests {
+		info := testTorrent(testData(1024), 1024)
+
+		if tt.private {
+			info.Private = true
+		}
+
+		path := writeTestTorrent(t, info, "http://127.0.0.1:1/announce")
+
+		client, err := NewTorrentClient(path, WithDHTBootstrap([]string{"127.0.0.1:1"}))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer client.Close()
+
+		if got := client.IsPrivate(); got != tt.private {
+			t.Errorf("%s: IsPrivate() = %v, want %v", tt.name, got, tt.private)
+		}
+
+		peer := &peerConn{addr: "192.0.2.1:6881", extensions: map[string]int{}}
+
+		client.handleExtended(peer, append([]byte{utPexID}, pex...))
+
+		if got := slices.Contains(client.Peers, "192.0.2.7:6881"); got == tt.private {
+			t.Errorf("%s: peer from peer exchange added = %v, want %v", tt.name, got, !tt.private)
+		}
+
+		if err := client.addDHTNode(peer, &PeerMessage{ID: MsgPort, Payload: []byte{0x1a, 0xe2}}); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := len(client.dhtNodes) > 0; got == tt.private {
+			t.Errorf("%s: dht node from port message added = %v, want %v", tt.name, got, !tt.private)
+		}
+
+		if !tt.private {
+			continue
+		}
+
+		// The lookup would otherwise contact the bootstrap node.
+		if _, err := client.dhtPeers(); err == nil {
+			t.Errorf("%s: dhtPeers() succeeded, want it disabled", tt.name)
+		}
+	}
+}
@@ -0,0 +1,795 @@
+package torrent
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+const (
+	defaultStallTimeout = 2 * time.Minute
+	stallCheckInterval  = time.Second
+)
+
+// ErrEndgameStalled is returned when every remaining piece is owed by peers
+// that stopped sending data for longer than the stall timeout.
+var ErrEndgameStalled = errors.New("endgame stalled: no progress on the remaining pieces")
+
+const (
+	// defaultPieceAttempts is how many times a single piece may fail, from
+	// any peers, before the download gives up on it. Past that point the
+	// torrent's hash is more likely wrong than every peer being bad.
+	defaultPieceAttempts = 8
+
+	// defaultPieceBackoff is how long a failed piece waits before it is
+	// handed out again, doubling with every further failure.
+	defaultPieceBackoff = time.Second
+)
+
+type pieceResult struct {
+	index int
+	data  []byte
+	peer  string
+
+	// err aborts the download, e.g. for a piece that never verifies.
+	err error
+}
+
+func (client *TorrentClient) Download(outputFileName string) error {
+	return client.DownloadContext(context.Background(), outputFileName)
+}
+
+// DownloadContext is Download with cancellation. When ctx is done (or the
+// client is closed) the peer connections are closed, which unblocks any
+// pending reads, progress is saved for a later resume and ctx's error (or
+// ErrClosed) is returned.
+//
+// If an earlier run for the same torrent left a resume state file next to the
+// output, the download picks up where it stopped instead of starting over.
+func (client *TorrentClient) DownloadContext(ctx context.Context, outputFileName string) error {
+	ctx, cancel := client.untilClosed(ctx)
+	defer cancel()
+
+	_, resume := client.loadResumeState(outputFileName)
+
+	for attempt := 0; ; attempt++ {
+		err := client.download(ctx, outputFileName, resume || attempt > 0)
+
+		if err == nil || errors.Is(err, ErrByteCapReached) || ctx.Err() != nil || attempt >= client.downloadRetries {
+			return err
+		}
+
+		select {
+		case <-time.After(client.retryBackoff << attempt):
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+	}
+}
+
+// DownloadPiece fetches and verifies a single piece, trying the known peers
+// (announcing first if there are none) until one that has it delivers.
+func (client *TorrentClient) DownloadPiece(index int) ([]byte, error) {
+	if index < 0 || index >= client.pieceCount() {
+		return nil, fmt.Errorf("piece %d out of range (torrent has %d pieces)", index, client.pieceCount())
+	}
+
+	if len(client.knownPeers()) == 0 {
+		if err := client.discoverPeers(client.lifetime); err != nil {
+			return nil, err
+		}
+	}
+
+	lastErr := fmt.Errorf("no peer has piece %d", index)
+
+	for _, addr := range client.knownPeers() {
+		piece, err := client.downloadPieceFrom(addr, index)
+
+		if err == nil {
+			return piece, nil
+		}
+
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+func (client *TorrentClient) downloadPieceFrom(addr string, index int) ([]byte, error) {
+	peer, err := client.connect(addr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer client.disconnectPeer(peer)
+
+	if !peer.has(index) {
+		return nil, fmt.Errorf("peer %s doesn't have piece %d", addr, index)
+	}
+
+	var piece []byte
+
+	err = client.requestPieces(peer, []int{index}, nil, func(_ int, data []byte) error {
+		if err := client.verifyPiece(index, data); err != nil {
+			return err
+		}
+
+		piece = data
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to download piece %d from %s: %w", index, addr, err)
+	}
+
+	return piece, nil
+}
+
+// download runs a single announce/connect/fetch cycle. When resume is set the
+// output file is kept and pieces that already verify are not fetched again.
+func (client *TorrentClient) download(ctx context.Context, outputFileName string, resume bool) error {
+	var err error
+
+	pieceCount := client.pieceCount()
+
+	done := NewBitfield(pieceCount)
+
+	if resume {
+		done, err = client.resumePieces(outputFileName)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	sched := newScheduler(client.picker, done, pieceCount)
+
+	// Count what is already on disk before the first announce, so trackers
+	// see the real number of bytes left.
+	client.piecesDone.Store(int64(pieceCount - sched.left()))
+	client.bytesDone.Store(client.completedBytes(done))
+
+	if err := client.discoverPeers(ctx); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outputFileName), client.dirMode); err != nil {
+		return fmt.Errorf("failed to create output directory: %v", err)
+	}
+
+	storage, err := client.openStorage(outputFileName, !resume)
+
+	if err != nil {
+		return err
+	}
+
+	defer storage.Close()
+
+	syncer := newSyncer(storage, client.syncPolicy)
+
+	// The resume state only lists pieces a sync has made durable, so a
+	// ResumeVerifyNone resume can trust it after a crash.
+	syncer.onSync = func() error {
+		return client.saveResumeState(outputFileName, sched.completed())
+	}
+
+	// Sync once more on the way out whatever the policy, so a download that
+	// never syncs can still be resumed.
+	defer func() {
+		if !sched.finished() {
+			syncer.sync()
+		}
+	}()
+
+	results := make(chan pieceResult)
+
+	stop := make(chan struct{})
+
+	// At most maxPeers workers run at once. Each walks the shared address
+	// queue, so when its peer fails or runs dry the slot moves on to the next
+	// peer instead of sitting idle. Peers found later by re-announces or peer
+	// exchange join the same queue.
+	var queue *peerQueue
+
+	queue = newPeerQueue(client.maxPeers, func() {
+		for {
+			addr, ok := queue.next()
+
+			if !ok {
+				return
+			}
+
+			select {
+			case <-stop:
+				queue.leave()
+				return
+			default:
+			}
+
+			if sched.finished() {
+				queue.leave()
+				return
+			}
+
+			client.runPeer(addr, sched, results, stop)
+		}
+	})
+
+	client.activePeers.Store(queue)
+	defer client.activePeers.CompareAndSwap(queue, nil)
+
+	queue.add(client.knownPeers())
+
+	go client.reannounceLoop(ctx, stop)
+
+	workersDone := queue.done
+
+	defer func() {
+		close(stop)
+		sched.stop()
+
+		for _, peer := range client.peerManager.all() {
+			client.disconnectPeer(peer)
+		}
+
+		<-workersDone
+	}()
+
+	watchdog := time.NewTicker(stallCheckInterval)
+	defer watchdog.Stop()
+
+	lastProgress := time.Now()
+
+	for !sched.finished() {
+		select {
+		case <-ctx.Done():
+			if err := syncer.flush(); err != nil {
+				return err
+			}
+
+			return context.Cause(ctx)
+		case <-watchdog.C:
+			if client.stallTimeout <= 0 || !sched.endgame() || time.Since(lastProgress) < client.stallTimeout {
+				continue
+			}
+
+			// Nothing new can be scheduled, so give up on these peers and
+			// let Download start a fresh discovery and reconnect cycle.
+			if err := syncer.flush(); err != nil {
+				return err
+			}
+
+			return fmt.Errorf("%w (%d of %d pieces remaining)", ErrEndgameStalled, sched.left(), pieceCount)
+		case result := <-results:
+			if result.err != nil {
+				return result.err
+			}
+
+			// In endgame another peer may have delivered this piece first.
+			if sched.isDone(result.index) {
+				continue
+			}
+
+			lastProgress = time.Now()
+
+			if err := storage.WriteBlock(result.index, 0, result.data); err != nil {
+				return err
+			}
+
+			sched.complete(result.index)
+			client.piecesDone.Add(1)
+			client.bytesDone.Add(client.pieceSize(result.index))
+
+			if err := syncer.pieceWritten(); err != nil {
+				return err
+			}
+
+			client.emit(Event{Type: EventPieceCompleted, Peer: result.peer, Piece: result.index})
+			client.reportProgress()
+		case <-workersDone:
+			if client.byteCapReached() {
+				client.announceEvent("stopped")
+
+				return ErrByteCapReached
+			}
+
+			return fmt.Errorf("no peers left with %d of %d pieces remaining", sched.left(), pieceCount)
+		}
+	}
+
+	if err := syncer.flush(); err != nil {
+		return err
+	}
+
+	if err := storage.Close(); err != nil {
+		return err
+	}
+
+	os.Remove(resumeStatePath(outputFileName))
+
+	client.announceEvent("completed")
+
+	client.emit(Event{Type: EventDownloadComplete})
+
+	return nil
+}
+
+// runPeer connects to a single peer and keeps fetching pieces from it until
+// the scheduler runs dry, the download stops or the peer fails. A piece that
+// fails mid-transfer goes back to the scheduler.
+func (client *TorrentClient) runPeer(addr string, sched *scheduler, results chan<- pieceResult, stop <-chan struct{}) {
+	peer, err := client.connect(addr)
+
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		client.disconnectPeer(peer)
+	}()
+
+	reconnects := 0
+
+	// corrupt marks the pieces this peer sent bad data for, so their retries
+	// go to other peers.
+	corrupt := NewBitfield(client.pieceCount())
+
+	for {
+		available := peer.pieces().without(corrupt)
+
+		batch := sched.nextBatch(available, client.peerShare(peer, sched.left()), stop)
+
+		if len(batch) == 0 {
+			return
+		}
+
+		delivered := make(map[int]bool, len(batch))
+
+		var fetched int64
+
+		started := time.Now()
+
+		wanted := func(index int) bool {
+			return !sched.isDone(index)
+		}
+
+		err := client.requestPieces(peer, batch, wanted, func(index int, piece []byte) error {
+			delivered[index] = true
+			fetched += int64(len(piece))
+
+			if err := client.verifyPiece(index, piece); err != nil {
+				corrupt.SetPiece(index)
+
+				if !client.retryPiece(sched, index, err, results, stop) {
+					return errStopped
+				}
+
+				if client.rejectPiece(peer, index, err) {
+					return errPeerBanned
+				}
+
+				return nil
+			}
+
+			select {
+			case results <- pieceResult{index: index, data: piece, peer: peer.addr}:
+				sched.handedOff(index)
+
+				return nil
+			case <-stop:
+				return errStopped
+			}
+		})
+
+		peer.recordRate(fetched, time.Since(started))
+
+		var failed []int
+
+		// Pieces another peer finished in the meantime need no retry.
+		for _, index := range batch {
+			if !delivered[index] && !sched.isDone(index) {
+				failed = append(failed, index)
+			}
+		}
+
+		if err == nil {
+			continue
+		}
+
+		// Pieces cut short by a ban or by stopping aren't at fault, so they go
+		// straight back without counting as a failed attempt.
+		if errors.Is(err, errPeerBanned) || errors.Is(err, errStopped) || errors.Is(err, ErrByteCapReached) {
+			for _, index := range failed {
+				sched.requeue(index)
+			}
+
+			if errors.Is(err, ErrByteCapReached) {
+				sched.stop()
+			}
+
+			return
+		}
+
+		// The connection failed, not the pieces: only hash failures, handled
+		// in deliver above, count toward pieceAttempts. A dropped connection,
+		// a choke timeout, a rejected request or DisconnectPeer just hands
+		// the pieces back.
+		for _, index := range failed {
+			client.emit(Event{Type: EventPieceFailed, Peer: peer.addr, Piece: index, Err: err})
+
+			sched.requeue(index)
+		}
+
+		if !errors.Is(err, ErrDesync) || reconnects >= maxReconnects {
+			return
+		}
+
+		reconnects++
+
+		client.disconnectPeer(peer)
+
+		reconnected, err := client.connect(addr)
+
+		if err != nil {
+			return
+		}
+
+		peer = reconnected
+	}
+}
+
+// retryPiece hands a piece that failed verification back to the scheduler
+// after a backoff that doubles with every failure, giving other peers the
+// chance to pick it up.
+// Once the piece has failed pieceAttempts times the download is aborted
+// instead and retryPiece returns false.
+func (client *TorrentClient) retryPiece(sched *scheduler, index int, cause error, results chan<- pieceResult, stop <-chan struct{}) bool {
+	if sched.isDone(index) {
+		return true
+	}
+
+	failures := sched.failed(index)
+
+	if failures >= client.pieceAttempts {
+		sched.requeue(index)
+
+		select {
+		case results <- pieceResult{index: index, err: fmt.Errorf("piece %d: giving up after %d failed attempts: %w", index, failures, cause)}:
+		case <-stop:
+		}
+
+		return false
+	}
+
+	sched.requeueAfter(index, client.pieceBackoff<<(failures-1))
+
+	return true
+}
+
+type blockKey struct {
+	index int
+	begin int
+}
+
+type blockRequest struct {
+	blockKey
+	length int
+}
+
+// requestPieces fetches several pieces from one peer at once, keeping up to
+// client.pipelineDepth block requests outstanding. Each piece is passed to deliver
+// as soon as its last block arrives; if deliver returns an error the fetch
+// stops with it. On any error the pieces not yet delivered are the caller's
+// to requeue. A piece for which wanted (if not nil) turns false, because
+// another peer finished it in endgame, is dropped without being delivered.
+func (client *TorrentClient) requestPieces(peer *peerConn, indices []int, wanted func(index int) bool, deliver func(index int, piece []byte) error) error {
+	var queue []blockRequest
+
+	buffers := make(map[int][]byte, len(indices))
+	missing := make(map[int]int, len(indices))
+
+	for _, index := range indices {
+		pieceSize := client.pieceSize(index)
+
+		blockCount := int(math.Ceil(float64(pieceSize) / float64(client.blockSize)))
+
+		if pieceSize <= 0 || blockCount <= 0 {
+			return fmt.Errorf("piece %d: %w: piece size %d in %d blocks", index, ErrInvalidGeometry, pieceSize, blockCount)
+		}
+
+		for i := 0; i < blockCount; i++ {
+			length, err := blockLength(pieceSize, client.blockSize, blockCount, i)
+			if err != nil {
+				return fmt.Errorf("piece %d: %w", index, err)
+			}
+
+			queue = append(queue, blockRequest{blockKey{index, i * client.blockSize}, length})
+		}
+
+		buffers[index] = make([]byte, pieceSize)
+		missing[index] = blockCount
+	}
+
+	pending := make(map[blockKey]int)
+
+	peer.chokedAt = time.Now()
+
+	for len(queue) > 0 || len(pending) > 0 {
+		if wanted != nil {
+			for index := range buffers {
+				if !wanted(index) {
+					remaining, err := dropPiece(peer.conn, queue, pending, index)
+					if err != nil {
+						return err
+					}
+
+					queue = remaining
+
+					delete(buffers, index)
+				}
+			}
+
+			if len(queue) == 0 && len(pending) == 0 {
+				break
+			}
+		}
+
+		for !peer.choked && len(queue) > 0 && len(pending) < client.pipelineDepth {
+			if client.byteCapReached() {
+				return ErrByteCapReached
+			}
+
+			if err := sendRequest(peer.conn, queue[0]); err != nil {
+				return err
+			}
+
+			pending[queue[0].blockKey] = queue[0].length
+			queue = queue[1:]
+		}
+
+		msg, err := client.readPieceMessage(peer)
+		if err != nil {
+			return err
+		}
+
+		switch msg.ID {
+		case MsgChoke:
+			if !peer.choked {
+				peer.choked = true
+				peer.chokedAt = time.Now()
+			}
+
+			// A choking peer discards our outstanding requests, so they are
+			// sent again once it unchokes us.
+			for key, length := range pending {
+				queue = append(queue, blockRequest{key, length})
+			}
+
+			clear(pending)
+
+			continue
+		case MsgUnchoke:
+			peer.choked = false
+
+			continue
+		case MsgRejectRequest:
+			if len(msg.Payload) != 12 {
+				return fmt.Errorf("%w: reject message with %d byte payload", ErrDesync, len(msg.Payload))
+			}
+
+			key := blockKey{
+				index: int(binary.BigEndian.Uint32(msg.Payload[0:4])),
+				begin: int(binary.BigEndian.Uint32(msg.Payload[4:8])),
+			}
+
+			// Fast extension peers reject whatever a choke dropped; only a
+			// request we are still waiting for counts.
+			if _, ok := pending[key]; ok {
+				return ErrRequestRejected
+			}
+
+			continue
+		}
+
+		if len(msg.Payload) < 8 {
+			return fmt.Errorf("%w: piece message too short (%d bytes)", ErrDesync, len(msg.Payload))
+		}
+
+		key := blockKey{
+			index: int(binary.BigEndian.Uint32(msg.Payload[0:4])),
+			begin: int(binary.BigEndian.Uint32(msg.Payload[4:8])),
+		}
+
+		block := msg.Payload[8:]
+
+		length, ok := pending[key]
+
+		// A block we didn't ask for (or no longer wait for) is dropped.
+		if !ok {
+			continue
+		}
+
+		if len(block) != length {
+			return fmt.Errorf("%w: piece %d block at %d has %d bytes, requested %d", ErrDesync, key.index, key.begin, len(block), length)
+		}
+
+		delete(pending, key)
+
+		client.downloadLimiter.wait(client.DownloadRateLimit, len(msg.Payload))
+
+		copy(buffers[key.index][key.begin:], block)
+
+		client.downloaded.Add(int64(len(block)))
+		client.metrics.AddCounter(MetricBytesDownloaded, int64(len(block)))
+
+		missing[key.index]--
+
+		if missing[key.index] == 0 {
+			piece := buffers[key.index]
+			delete(buffers, key.index)
+
+			if err := deliver(key.index, piece); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// dropPiece cancels the outstanding requests for piece index, forgets the
+// queued ones and returns what is left of the queue. Blocks of the piece that
+// still arrive are ignored.
+func dropPiece(conn net.Conn, queue []blockRequest, pending map[blockKey]int, index int) ([]blockRequest, error) {
+	for key, length := range pending {
+		if key.index != index {
+			continue
+		}
+
+		if err := sendCancel(conn, blockRequest{key, length}); err != nil {
+			return nil, err
+		}
+
+		delete(pending, key)
+	}
+
+	return slices.DeleteFunc(queue, func(req blockRequest) bool {
+		return req.index == index
+	}), nil
+}
+
+func sendRequest(conn net.Conn, req blockRequest) error {
+	if err := writeBlockMessage(conn, MsgRequest, req); err != nil {
+		return fmt.Errorf("failed to send piece request: %v", err)
+	}
+
+	return nil
+}
+
+// sendCancel withdraws a request; the index, begin and length must match the
+// request exactly for the peer to drop it.
+func sendCancel(conn net.Conn, req blockRequest) error {
+	if err := writeBlockMessage(conn, MsgCancel, req); err != nil {
+		return fmt.Errorf("failed to send cancel: %v", err)
+	}
+
+	return nil
+}
+
+// writeBlockMessage writes a request or cancel message, which share a layout.
+func writeBlockMessage(conn net.Conn, id uint8, req blockRequest) error {
+	request := struct {
+		LengthPrefix uint32
+		ID           uint8
+		Index        uint32
+		Begin        uint32
+		Length       uint32
+	}{
+		LengthPrefix: 13,
+		ID:           id,
+		Index:        uint32(req.index),
+		Begin:        uint32(req.begin),
+		Length:       uint32(req.length),
+	}
+
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.BigEndian, request); err != nil {
+		return fmt.Errorf("failed to serialize message: %v", err)
+	}
+
+	_, err := conn.Write(buf.Bytes())
+
+	return err
+}
+
+var ErrInvalidGeometry = errors.New("invalid piece geometry")
+
+var ErrByteCapReached = errors.New("download byte cap reached")
+
+var (
+	errPeerBanned = errors.New("peer banned")
+	errStopped    = errors.New("download stopped")
+)
+
+// ErrRequestRejected is returned when a fast extension peer refuses a block
+// request instead of ignoring it.
+var ErrRequestRejected = errors.New("peer rejected the request")
+
+// chokeTimeout is how long a peer may keep us choked in the middle of a batch
+// before its pieces are handed to other peers.
+const chokeTimeout = 30 * time.Second
+
+var ErrChokeTimeout = errors.New("peer kept us choked")
+
+func (client *TorrentClient) byteCapReached() bool {
+	return client.downloadByteCap > 0 && client.downloaded.Load() >= client.downloadByteCap
+}
+
+// blockLength returns the length of block i of a piece. Every block is
+// blockSize long except the last, which takes whatever is left; a piece whose
+// size doesn't fit its block count is rejected rather than producing a zero
+// or negative length that would wrap when sent as uint32.
+func blockLength(pieceSize int64, blockSize, blockCount, i int) (int, error) {
+	if pieceSize <= 0 || blockSize <= 0 {
+		return 0, fmt.Errorf("%w: piece size %d, block size %d", ErrInvalidGeometry, pieceSize, blockSize)
+	}
+
+	if i < 0 || i >= blockCount || int64(blockCount-1)*int64(blockSize) >= pieceSize || int64(blockCount)*int64(blockSize) < pieceSize {
+		return 0, fmt.Errorf("%w: block %d of %d for piece size %d", ErrInvalidGeometry, i, blockCount, pieceSize)
+	}
+
+	if i < blockCount-1 {
+		return blockSize, nil
+	}
+
+	return int(pieceSize - int64(blockCount-1)*int64(blockSize)), nil
+}
+
+// readPieceMessage waits for the next piece, choke, unchoke or reject message,
+// applying have messages that arrive in the meantime to the peer's bitfield.
+// A peer that keeps us choked past chokeTimeout fails with ErrChokeTimeout,
+// even if it keeps sending other messages.
+func (client *TorrentClient) readPieceMessage(peer *peerConn) (*PeerMessage, error) {
+	for {
+		if peer.choked && time.Since(peer.chokedAt) > chokeTimeout {
+			return nil, fmt.Errorf("%w after %v", ErrChokeTimeout, chokeTimeout)
+		}
+
+		msg, err := client.readPeerMessage(peer.conn)
+		if err != nil {
+			return nil, err
+		}
+
+		if msg == nil {
+			continue
+		}
+
+		switch msg.ID {
+		case MsgPiece, MsgChoke, MsgUnchoke, MsgRejectRequest:
+			return msg, nil
+		case MsgHave:
+			index, err := parseHave(msg)
+			if err != nil {
+				return nil, err
+			}
+
+			client.peerHas(peer, index)
+		case MsgExtended:
+			client.handleExtended(peer, msg.Payload)
+		case MsgPort:
+			if err := client.addDHTNode(peer, msg); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
@@ -0,0 +1,647 @@
+package torrent
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/decoder"
+)
+
+func TestReadPieceMessageChokeTimeoutWhileReceivingHaves(t *testing.T) {
+	ours, theirs := net.Pipe()
+	defer ours.Close()
+	defer theirs.Close()
+
+	client := &TorrentClient{File: TorrentFile{Info: MetaInfo{Length: 64, PieceLength: 16}}}
+
+	peer := &peerConn{
+		conn:     ours,
+		bitfield: NewBitfield(4),
+		choked:   true,
+		chokedAt: time.Now().Add(-chokeTimeout + 100*time.Millisecond),
+	}
+
+	// The peer never unchokes but keeps the connection busy with haves.
+	go func() {
+		for i := 0; ; i = (i + 1) % 4 {
+			msg := []byte{0, 0, 0, 5, MsgHave, 0, 0, 0, 0}
+			binary.BigEndian.PutUint32(msg[5:], uint32(i))
+
+			if _, err := theirs.Write(msg); err != nil {
+				return
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	done := make(chan error, 1)
+
+	go func() {
+		_, err := client.readPieceMessage(peer)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrChokeTimeout) {
+			t.Fatalf("readPieceMessage() error = %v, want ErrChokeTimeout", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("readPieceMessage kept reading haves past the choke timeout")
+	}
+}
+
+func TestDownloadRequeuesPiecesLostToDroppedConnections(t *testing.T) {
+	data := testData(8*16*1024 + 100)
+
+	// The first peer hangs up on every request. With a single attempt per
+	// piece, counting those as failures would abort the download.
+	peers := []*testPeer{
+		{serve: func(index, begin, length int) bool { return false }},
+		{},
+	}
+
+	client := startSwarm(t, data, 16*1024, peers, WithPieceRetries(1, 0))
+
+	output := filepath.Join(t.TempDir(), "out")
+
+	if err := client.Download(output); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(output)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, data) {
+		t.Fatal("downloaded data differs")
+	}
+}
+
+func TestDownloadGivesUpOnPieceThatNeverVerifies(t *testing.T) {
+	data := testData(4 * 16 * 1024)
+
+	client := startSwarm(t, data, 16*1024, []*testPeer{{corrupt: true}}, WithPieceRetries(1, 0))
+
+	err := client.Download(filepath.Join(t.TempDir(), "out"))
+
+	if !errors.Is(err, ErrPieceHashMismatch) {
+		t.Fatalf("Download() error = %v, want ErrPieceHashMismatch", err)
+	}
+}
+
+func TestDownloadFetchesPiecesAnnouncedWithHave(t *testing.T) {
+	data := testData(4 * 16 * 1024)
+
+	// The only peer starts without piece 2 and announces it mid-download, so
+	// finishing depends on the have message reaching the scheduler.
+	client := startSwarm(t, data, 16*1024, []*testPeer{{missing: []int{2}}}, WithPiecePicker(&SequentialPicker{PieceCount: 4}))
+
+	output := filepath.Join(t.TempDir(), "out")
+
+	if err := client.Download(output); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(output)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, data) {
+		t.Fatal("downloaded data differs")
+	}
+}
+
+func TestDownloadReconnectsAfterDesync(t *testing.T) {
+	data := testData(4 * 16 * 1024)
+
+	// A length prefix far beyond any real message, followed by junk, in place
+	// of the first block.
+	peer := &testPeer{garbage: append(frame(0x7fffffff), "junk"...)}
+
+	client := startSwarm(t, data, 16*1024, []*testPeer{peer})
+
+	output := filepath.Join(t.TempDir(), "out")
+
+	if err := client.Download(output); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(output)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, data) {
+		t.Fatal("downloaded data differs")
+	}
+
+	if conns := peer.conns.Load(); conns < 2 {
+		t.Fatalf("peer saw %d connections, want a reconnect after the desync", conns)
+	}
+}
+
+func TestDownloadRetriesWithFreshAnnounce(t *testing.T) {
+	data := testData(4 * 16 * 1024)
+
+	live, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer live.Close()
+
+	// Nothing listens on dead once it is closed, so every connection fails.
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dead.Close()
+
+	var announces atomic.Int32
+
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr := dead.Addr().String()
+
+		if announces.Add(1) > 1 {
+			addr = live.Addr().String()
+		}
+
+		host, port, _ := net.SplitHostPort(addr)
+		portNum, _ := strconv.Atoi(port)
+
+		peers := []any{map[string]any{"ip": host, "port": portNum}}
+		resp, _ := decoder.Encode(map[string]any{"interval": 60, "peers": peers})
+		w.Write(resp)
+	}))
+	defer tracker.Close()
+
+	path := writeTestTorrent(t, testTorrent(data, 16*1024), tracker.URL+"/announce")
+
+	client, err := NewTorrentClient(path, WithDHTBootstrap(nil), WithDownloadRetries(2, 10*time.Millisecond))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peer := &testPeer{data: data, pieceLen: 16 * 1024, infoHash: client.InfoHash}
+	go peer.run(live)
+
+	output := filepath.Join(t.TempDir(), "out")
+
+	if err := client.Download(output); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(output)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, data) {
+		t.Fatal("downloaded data differs")
+	}
+
+	if announces.Load() < 2 {
+		t.Fatalf("tracker saw %d announces, want a fresh one for the retry", announces.Load())
+	}
+}
+
+func TestBlockLength(t *testing.T) {
+	tests := []struct {
+		name       string
+		pieceSize  int64
+		blockSize  int
+		blockCount int
+		i          int
+		want       int
+		wantErr    bool
+	}{
+		{"full block", 32 * 1024, 16 * 1024, 2, 0, 16 * 1024, false},
+		{"exact last block", 32 * 1024, 16 * 1024, 2, 1, 16 * 1024, false},
+		{"short last block", 20 * 1024, 16 * 1024, 2, 1, 4 * 1024, false},
+		{"single short block", 10, 16 * 1024, 1, 0, 10, false},
+		{"one block too many", 32 * 1024, 16 * 1024, 3, 2, 0, true},
+		{"one block too few", 32 * 1024, 16 * 1024, 1, 0, 0, true},
+		{"past the last block", 32 * 1024, 16 * 1024, 2, 2, 0, true},
+		{"negative index", 32 * 1024, 16 * 1024, 2, -1, 0, true},
+		{"empty piece", 0, 16 * 1024, 1, 0, 0, true},
+		{"zero block size", 32 * 1024, 0, 2, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := blockLength(tt.pieceSize, tt.blockSize, tt.blockCount, tt.i)
+
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidGeometry) {
+				t.Errorf("%s: blockLength() error = %v, want ErrInvalidGeometry", tt.name, err)
+			}
+
+			continue
+		}
+
+		if err != nil || got != tt.want {
+			t.Errorf("%s: blockLength() = %d, %v, want %d", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestDownloadExactMultipleRequestsFullLastBlock(t *testing.T) {
+	const pieceLen = 32 * 1024
+
+	data := testData(4 * pieceLen)
+
+	var mu sync.Mutex
+
+	var lastPiece []int
+
+	record := func(index, begin, length int) bool {
+		if index == 3 {
+			mu.Lock()
+			lastPiece = append(lastPiece, begin, length)
+			mu.Unlock()
+		}
+
+		return true
+	}
+
+	client := startSwarm(t, data, pieceLen, []*testPeer{{serve: record}})
+
+	if got := client.pieceSize(3); got != pieceLen {
+		t.Fatalf("pieceSize(3) = %d, want %d", got, pieceLen)
+	}
+
+	output := filepath.Join(t.TempDir(), "out")
+
+	if err := client.Download(output); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(lastPiece) == 0 {
+		t.Fatal("the last piece was never requested")
+	}
+
+	for i := 0; i < len(lastPiece); i += 2 {
+		if begin, length := lastPiece[i], lastPiece[i+1]; length <= 0 || begin+length > pieceLen {
+			t.Fatalf("requested %d bytes at %d of the last piece", length, begin)
+		}
+	}
+
+	got, err := os.ReadFile(output)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, data) {
+		t.Fatal("downloaded data differs")
+	}
+}
+
+func TestDownloadStopsAtByteCap(t *testing.T) {
+	const pieceLen = 16 * 1024
+
+	data := testData(16 * pieceLen)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ln.Close()
+
+	var mu sync.Mutex
+
+	var events []string
+
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		events = append(events, r.URL.Query().Get("event"))
+		mu.Unlock()
+
+		addr := ln.Addr().(*net.TCPAddr)
+		peers := []any{map[string]any{"ip": "127.0.0.1", "port": addr.Port}}
+		resp, _ := decoder.Encode(map[string]any{"interval": 60, "peers": peers})
+		w.Write(resp)
+	}))
+	defer tracker.Close()
+
+	path := writeTestTorrent(t, testTorrent(data, pieceLen), tracker.URL+"/announce")
+
+	const byteCap = 3*pieceLen + 100
+
+	client, err := NewTorrentClient(path, WithDHTBootstrap(nil), WithDownloadByteCap(byteCap))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go (&testPeer{data: data, pieceLen: pieceLen, infoHash: client.InfoHash}).run(ln)
+
+	err = client.Download(filepath.Join(t.TempDir(), "out"))
+
+	if !errors.Is(err, ErrByteCapReached) {
+		t.Fatalf("Download() = %v, want ErrByteCapReached", err)
+	}
+
+	// Requests already in flight when the cap is hit still arrive.
+	downloaded := client.downloaded.Load()
+
+	if downloaded < byteCap || downloaded > byteCap+int64(client.pipelineDepth*client.blockSize) {
+		t.Fatalf("downloaded %d bytes with a cap of %d", downloaded, byteCap)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if !slices.Contains(events, "stopped") {
+		t.Fatalf("tracker events = %q, want a stopped announce", events)
+	}
+}
+
+func TestDownloadEndgameWatchdogFires(t *testing.T) {
+	data := testData(6 * 16 * 1024)
+
+	release := make(chan struct{})
+	defer close(release)
+
+	// Both peers serve everything but never answer for the last piece.
+	stall := func(index, begin, length int) bool {
+		if index == 5 {
+			<-release
+			return false
+		}
+
+		return true
+	}
+
+	client := startSwarm(t, data, 16*1024, []*testPeer{{serve: stall}, {serve: stall}}, WithStallTimeout(100*time.Millisecond))
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- client.Download(filepath.Join(t.TempDir(), "out"))
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrEndgameStalled) {
+			t.Fatalf("Download() = %v, want ErrEndgameStalled", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("watchdog never fired")
+	}
+}
+
+func TestDownloadCreatesMissingDirectories(t *testing.T) {
+	data := testData(2*16*1024 + 10)
+
+	client := startSwarm(t, data, 16*1024, []*testPeer{{}}, WithDirMode(0700))
+
+	root := t.TempDir()
+	output := filepath.Join(root, "a", "b", "out")
+
+	if err := client.Download(output); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := os.ReadFile(output); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("output not written: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(root, "a"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mode := info.Mode().Perm(); mode != 0700 {
+		t.Fatalf("created directory mode = %v, want 0700", mode)
+	}
+}
+
+func TestMultiFileStorageCreatesMissingDirectories(t *testing.T) {
+	info := MetaInfo{
+		Name:        "torrent",
+		PieceLength: 16,
+		Pieces:      string(make([]byte, 40)),
+		Files: []FileInfo{
+			{Length: 10, Path: []string{"a.txt"}},
+			{Length: 10, Path: []string{"sub", "dir", "b.txt"}},
+		},
+	}
+
+	client, err := NewTorrentClient(writeTestTorrent(t, info, "http://tracker/announce"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := filepath.Join(t.TempDir(), "not", "there", "yet")
+
+	storage, err := client.openStorage(root, true)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage.Close()
+
+	if _, err := os.Stat(filepath.Join(root, "torrent", "sub", "dir", "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDownloadReportsUncreatableDirectory(t *testing.T) {
+	data := testData(16 * 1024)
+
+	client := startSwarm(t, data, 16*1024, []*testPeer{{}})
+
+	// A regular file where a directory is needed.
+	blocker := filepath.Join(t.TempDir(), "file")
+
+	if err := os.WriteFile(blocker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := client.Download(filepath.Join(blocker, "sub", "out"))
+
+	if err == nil || !strings.Contains(err.Error(), "failed to create output directory") {
+		t.Fatalf("Download() = %v, want a directory creation error", err)
+	}
+}
+
+func TestDownloadBlamesPeerSendingCorruptPieces(t *testing.T) {
+	data := testData(8 * 16 * 1024)
+
+	bad, good := &testPeer{corrupt: true}, &testPeer{}
+
+	client := startSwarm(t, data, 16*1024, []*testPeer{bad, good}, WithEventDelivery(256, true))
+
+	events := client.Events()
+
+	if err := client.Download(filepath.Join(t.TempDir(), "out")); err != nil {
+		t.Fatal(err)
+	}
+
+	scores := client.BanScores()
+
+	if scores[bad.addr] == 0 {
+		t.Fatalf("ban scores = %v, want %s blamed", scores, bad.addr)
+	}
+
+	if scores[good.addr] != 0 {
+		t.Fatalf("ban scores = %v, want %s blameless", scores, good.addr)
+	}
+
+	for len(events) > 0 {
+		event := <-events
+
+		if event.Type == EventPieceFailed && event.Peer != bad.addr {
+			t.Fatalf("piece %d failure attributed to %s, want %s", event.Piece, event.Peer, bad.addr)
+		}
+
+		if event.Type == EventPeerBanned && event.Peer != bad.addr {
+			t.Fatalf("%s banned, want only %s", event.Peer, bad.addr)
+		}
+	}
+}
+
+func TestDownloadTinySingleBlockPiece(t *testing.T) {
+	data := testData(10)
+
+	var mu sync.Mutex
+
+	var lengths []int
+
+	record := func(index, begin, length int) bool {
+		mu.Lock()
+		lengths = append(lengths, length)
+		mu.Unlock()
+
+		return true
+	}
+
+	client := startSwarm(t, data, 64, []*testPeer{{serve: record}}, WithBlockSize(32))
+
+	output := filepath.Join(t.TempDir(), "out")
+
+	if err := client.Download(output); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(lengths) != 1 || lengths[0] != len(data) {
+		t.Fatalf("requested blocks of %v bytes, want one of %d", lengths, len(data))
+	}
+
+	got, err := os.ReadFile(output)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, data) {
+		t.Fatal("downloaded data differs")
+	}
+}
+
+func TestDownloadSavesResumeStateOnExitWithSyncNever(t *testing.T) {
+	const pieceLen = 16 * 1024
+
+	data := testData(16 * pieceLen)
+
+	client := startSwarm(t, data, pieceLen, []*testPeer{{}},
+		WithSyncPolicy(SyncPolicy{Mode: SyncNever}), WithDownloadByteCap(3*pieceLen+100))
+
+	output := filepath.Join(t.TempDir(), "out")
+
+	if err := client.Download(output); !errors.Is(err, ErrByteCapReached) {
+		t.Fatalf("Download() = %v, want ErrByteCapReached", err)
+	}
+
+	have, ok := client.loadResumeState(output)
+
+	if !ok {
+		t.Fatal("no resume state saved with SyncNever")
+	}
+
+	saved := 0
+
+	for i := 0; i < client.pieceCount(); i++ {
+		if have.HasPiece(i) {
+			saved++
+		}
+	}
+
+	if want := int(client.piecesDone.Load()); saved != want || saved < 3 {
+		t.Fatalf("resume state has %d pieces, want the %d completed", saved, want)
+	}
+}
+
+func TestDownloadPieceWhilePeersAreAdded(t *testing.T) {
+	data := testData(4 * 1024)
+	client := startSwarm(t, data, 1024, []*testPeer{{}})
+
+	done := make(chan struct{})
+	added := make(chan struct{})
+
+	// Peer exchange keeps adding addresses while the piece is fetched; run
+	// with -race to catch unsynchronized reads of Peers.
+	go func() {
+		defer close(added)
+
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			client.addPeers([]string{fmt.Sprintf("192.0.2.%d:6881", i%250+1)})
+		}
+	}()
+
+	piece, err := client.DownloadPiece(2)
+
+	close(done)
+	<-added
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(piece, data[2*1024:3*1024]) {
+		t.Fatal("downloaded piece differs")
+	}
+}
@@ -0,0 +1,130 @@
+package torrent
+
+import (
+	"fmt"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/decoder"
+)
+
+// Encode serializes the torrent back to .torrent bytes. If the torrent was
+// parsed from a file its info dict is written back byte for byte, so the info
+// hash is unchanged even if the dict had keys MetaInfo doesn't model.
+func (t TorrentFile) Encode() ([]byte, error) {
+	dict := map[string]any{
+		"announce": t.Announce,
+	}
+
+	if len(t.AnnounceList) > 0 {
+		dict["announce-list"] = t.AnnounceList
+	}
+
+	if t.Comment != "" {
+		dict["comment"] = t.Comment
+	}
+
+	if t.CreatedBy != "" {
+		dict["created by"] = t.CreatedBy
+	}
+
+	if t.CreationDate != 0 {
+		dict["creation date"] = t.CreationDate
+	}
+
+	if t.rawInfo != nil {
+		dict["info"] = decoder.RawMessage(t.rawInfo)
+	} else {
+		dict["info"] = t.Info.dict()
+	}
+
+	return decoder.Encode(dict)
+}
+
+// infoKeys are the info dict keys MetaInfo models; everything else lands in
+// Extra.
+var infoKeys = []string{"name", "name.utf-8", "pieces", "piece length", "length", "files", "private"}
+
+// captureExtra records the keys of the raw info dict that MetaInfo doesn't
+// model.
+func (m *MetaInfo) captureExtra(rawInfo []byte) error {
+	decoded, err := decoder.New(rawInfo).Decode()
+
+	if err != nil {
+		return err
+	}
+
+	dict, ok := decoded.(map[string]any)
+
+	if !ok {
+		return fmt.Errorf("info is a %T, not a dict", decoded)
+	}
+
+	for _, key := range infoKeys {
+		delete(dict, key)
+	}
+
+	if len(dict) > 0 {
+		m.Extra = dict
+	} else {
+		m.Extra = nil
+	}
+
+	return nil
+}
+
+// IsPrivate reports whether the torrent sets the BEP 27 private flag, which
+// limits peer discovery to its own trackers.
+func (m MetaInfo) IsPrivate() bool {
+	return m.Private
+}
+
+func (m MetaInfo) dict() map[string]any {
+	dict := make(map[string]any, len(m.Extra)+5)
+
+	for key, value := range m.Extra {
+		dict[key] = value
+	}
+
+	dict["name"] = m.Name
+	dict["pieces"] = m.Pieces
+	dict["piece length"] = m.PieceLength
+
+	if m.isMultiFile() {
+		files := make([]any, len(m.Files))
+
+		for i, file := range m.Files {
+			path := stringList(file.Path)
+
+			entry := map[string]any{"length": file.Length, "path": path}
+
+			if len(file.PathUTF8) > 0 {
+				entry["path.utf-8"] = stringList(file.PathUTF8)
+			}
+
+			files[i] = entry
+		}
+
+		dict["files"] = files
+	} else {
+		dict["length"] = m.Length
+	}
+
+	if m.NameUTF8 != "" {
+		dict["name.utf-8"] = m.NameUTF8
+	}
+
+	if m.Private {
+		dict["private"] = 1
+	}
+
+	return dict
+}
+
+func stringList(parts []string) []any {
+	list := make([]any, len(parts))
+
+	for i, part := range parts {
+		list[i] = part
+	}
+
+	return list
+}
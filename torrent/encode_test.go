@@ -0,0 +1,113 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"strings"
+	"testing"
+)
+
+func TestEncodeKeepsInfoHash(t *testing.T) {
+	pieces := strings.Repeat("h", 20)
+
+	// Keys out of order and one MetaInfo doesn't model: re-encoding the
+	// parsed fields would change the hash, so the raw dict must survive.
+	info := "d6:lengthi10e4:name4:file12:piece lengthi16e6:pieces20:" + pieces + "6:source3:abc1:ai1ee"
+	data := "d8:announce23:http://tracker/announce7:comment5:hello4:info" + info + "e"
+
+	client, err := newTorrentClientFromBytes([]byte(data), nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := sha1.Sum([]byte(info)); client.InfoHash != want {
+		t.Fatalf("info hash = %x, want %x", client.InfoHash, want)
+	}
+
+	encoded, err := client.File.Encode()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(encoded), "4:info"+info) {
+		t.Fatalf("re-encoded torrent doesn't contain the original info dict: %q", encoded)
+	}
+
+	reparsed, err := newTorrentClientFromBytes(encoded, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reparsed.InfoHash != client.InfoHash {
+		t.Fatalf("info hash changed from %x to %x", client.InfoHash, reparsed.InfoHash)
+	}
+
+	if reparsed.File.Comment != "hello" || reparsed.File.Announce != "http://tracker/announce" {
+		t.Fatalf("top-level keys lost: %+v", reparsed.File)
+	}
+}
+
+func TestEncodeBuiltTorrent(t *testing.T) {
+	file := TorrentFile{
+		Announce:     "http://tracker/announce",
+		AnnounceList: [][]string{{"http://tracker/announce"}, {"http://backup/announce"}},
+		Info:         testTorrent(testData(100), 64),
+	}
+
+	encoded, err := file.Encode()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := newTorrentClientFromBytes(encoded, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if client.File.Info.Pieces != file.Info.Pieces || client.File.Info.Length != 100 || len(client.File.AnnounceList) != 2 {
+		t.Fatalf("decoded %+v, want %+v", client.File, file)
+	}
+}
+
+func TestParsePrivateFlag(t *testing.T) {
+	pieces := strings.Repeat("h", 20)
+
+	tests := []struct {
+		name string
+		flag string
+		want bool
+	}{
+		{"private", "7:privatei1e", true},
+		{"explicitly public", "7:privatei0e", false},
+		{"no flag", "", false},
+	}
+
+	for _, tt := range tests {
+		info := "d6:lengthi10e4:name4:file12:piece lengthi16e6:pieces20:" + pieces + tt.flag + "6:source3:abce"
+
+		client, err := newTorrentClientFromBytes([]byte("d8:announce23:http://tracker/announce4:info"+info+"e"), nil)
+
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if got := client.File.Info.Private; got != tt.want {
+			t.Errorf("%s: Private = %v, want %v", tt.name, got, tt.want)
+		}
+
+		if _, ok := client.File.Info.Extra["private"]; ok {
+			t.Errorf("%s: private landed in Extra", tt.name)
+		}
+
+		// A built torrent writes the flag back as the integer 1.
+		dict := client.File.Info.dict()
+
+		if got, ok := dict["private"]; ok != tt.want || (ok && got != 1) {
+			t.Errorf("%s: encoded private = %v, want it set only when private", tt.name, got)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package torrent
+
+import "sync"
+
+type EventType int
+
+const (
+	EventPeerConnected EventType = iota
+	EventPeerDisconnected
+	EventPieceCompleted
+	EventPieceFailed
+	EventAnnounceDone
+	EventDownloadComplete
+	EventWaitingForPeers
+	EventPeerBanned
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventPeerConnected:
+		return "peer connected"
+	case EventPeerDisconnected:
+		return "peer disconnected"
+	case EventPieceCompleted:
+		return "piece completed"
+	case EventPieceFailed:
+		return "piece failed"
+	case EventAnnounceDone:
+		return "announce done"
+	case EventDownloadComplete:
+		return "download complete"
+	case EventWaitingForPeers:
+		return "waiting for peers"
+	case EventPeerBanned:
+		return "peer banned"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes something that happened during a download. Only the fields
+// relevant to Type are set: Peer for peer events, Piece for piece events,
+// Peers (and Seeders/Leechers when the tracker reported them) for announces
+// and Err for failures.
+type Event struct {
+	Type     EventType
+	Peer     string
+	Piece    int
+	Peers    int
+	Seeders  int
+	Leechers int
+	Err      error
+}
+
+const defaultEventBuffer = 64
+
+type eventStream struct {
+	once       sync.Once
+	ch         chan Event
+	buffer     int
+	block      bool
+	subscribed bool
+	mu         sync.Mutex
+
+	// subs are extra listeners such as ProgressSSE. They never block the
+	// download: a full subscriber channel just misses the event.
+	subs map[chan Event]struct{}
+}
+
+func (s *eventStream) subscribe() chan Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.subs == nil {
+		s.subs = make(map[chan Event]struct{})
+	}
+
+	ch := make(chan Event, defaultEventBuffer)
+	s.subs[ch] = struct{}{}
+
+	return ch
+}
+
+func (s *eventStream) unsubscribe(ch chan Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.subs, ch)
+}
+
+// Events returns a channel of lifecycle events. Events are only produced once
+// this has been called. By default a full channel drops events rather than
+// stalling the download; see WithEventDelivery.
+func (client *TorrentClient) Events() <-chan Event {
+	s := &client.events
+
+	s.once.Do(func() {
+		buffer := s.buffer
+		if buffer <= 0 {
+			buffer = defaultEventBuffer
+		}
+
+		s.mu.Lock()
+		s.ch = make(chan Event, buffer)
+		s.subscribed = true
+		s.mu.Unlock()
+	})
+
+	return s.ch
+}
+
+func (client *TorrentClient) emit(event Event) {
+	client.recordMetrics(event)
+	client.logEvent(event)
+
+	s := &client.events
+
+	s.mu.Lock()
+	subscribed, block, ch := s.subscribed, s.block, s.ch
+
+	for sub := range s.subs {
+		select {
+		case sub <- event:
+		default:
+		}
+	}
+
+	s.mu.Unlock()
+
+	if !subscribed {
+		return
+	}
+
+	if block {
+		ch <- event
+		return
+	}
+
+	select {
+	case ch <- event:
+	default:
+	}
+}
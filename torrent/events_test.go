@@ -0,0 +1,68 @@
+package torrent
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestDownloadEventSequence(t *testing.T) {
+	data := testData(3 * 16 * 1024)
+
+	client := startSwarm(t, data, 16*1024, []*testPeer{{}}, WithEventDelivery(256, true))
+
+	events := client.Events()
+
+	if err := client.Download(filepath.Join(t.TempDir(), "out")); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []EventType
+
+	for len(events) > 0 {
+		event := <-events
+
+		// The peer may be disconnected before or after the download
+		// completes, so disconnects are left out.
+		if event.Type == EventPeerDisconnected {
+			continue
+		}
+
+		got = append(got, event.Type)
+	}
+
+	want := []EventType{
+		EventAnnounceDone,
+		EventPeerConnected,
+		EventPieceCompleted,
+		EventPieceCompleted,
+		EventPieceCompleted,
+		EventDownloadComplete,
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("events = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSlowEventConsumerDoesNotStallDownload(t *testing.T) {
+	data := testData(8 * 16 * 1024)
+
+	client := startSwarm(t, data, 16*1024, []*testPeer{{}}, WithEventDelivery(1, false))
+
+	// Subscribed but never drained: events beyond the buffer are dropped.
+	events := client.Events()
+
+	if err := client.Download(filepath.Join(t.TempDir(), "out")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 1 {
+		t.Fatalf("%d events buffered, want 1", len(events))
+	}
+}
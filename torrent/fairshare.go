@@ -0,0 +1,69 @@
+package torrent
+
+import "time"
+
+const (
+	// defaultPipelineDepth is how many block requests are kept outstanding
+	// per peer unless WithPipelineDepth says otherwise.
+	defaultPipelineDepth = 16
+
+	// maxPiecesPerPeer caps how many pieces one peer may hold, so a single
+	// failing peer never takes much in-flight work down with it.
+	maxPiecesPerPeer = 4
+)
+
+// recordRate folds a finished batch into the peer's byte count and measured
+// download rate.
+func (peer *peerConn) recordRate(bytes int64, elapsed time.Duration) {
+	peer.statsMu.Lock()
+	defer peer.statsMu.Unlock()
+
+	peer.downloaded += bytes
+
+	if bytes == 0 || elapsed <= 0 {
+		return
+	}
+
+	rate := float64(bytes) / elapsed.Seconds()
+
+	if peer.rate == 0 {
+		peer.rate = rate
+	} else {
+		peer.rate = 0.7*peer.rate + 0.3*rate
+	}
+}
+
+func (peer *peerConn) downloadRate() float64 {
+	peer.statsMu.Lock()
+	defer peer.statsMu.Unlock()
+
+	return peer.rate
+}
+
+// peerShare is how many pieces peer may hold at once. Peers start at one; a
+// peer faster than average gets more in proportion to its speed, but never
+// more than an even split of the remaining pieces across connected peers, so
+// everyone else stays busy.
+func (client *TorrentClient) peerShare(peer *peerConn, remaining int) int {
+	peers := client.peerManager.all()
+
+	if len(peers) == 0 {
+		return 1
+	}
+
+	var total float64
+
+	for _, p := range peers {
+		total += p.downloadRate()
+	}
+
+	share := 1
+
+	if rate := peer.downloadRate(); rate > 0 && total > 0 {
+		share = int(rate/(total/float64(len(peers))) + 0.5)
+	}
+
+	fair := min(remaining/len(peers), maxPiecesPerPeer)
+
+	return max(1, min(share, fair))
+}
@@ -0,0 +1,101 @@
+package torrent
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestPeerShare(t *testing.T) {
+	tests := []struct {
+		name      string
+		rates     []float64
+		remaining int
+		want      int
+	}{
+		{"unmeasured peer", []float64{0}, 100, 1},
+		{"faster than average", []float64{300, 100, 100}, 100, 2},
+		{"much faster", []float64{1000, 10, 10}, 100, 3},
+		{"capped per peer", []float64{10000, 1, 1, 1, 1}, 100, maxPiecesPerPeer},
+		{"capped by an even split", []float64{1000, 10, 10}, 3, 1},
+		{"slower than average", []float64{10, 1000, 10}, 100, 1},
+		{"nothing left", []float64{1000, 10}, 0, 1},
+	}
+
+	for _, tt := range tests {
+		client := &TorrentClient{}
+
+		var peers []*peerConn
+
+		for i, rate := range tt.rates {
+			peer := &peerConn{addr: fmt.Sprintf("192.0.2.%d:6881", i+1), rate: rate}
+			client.peerManager.add(peer)
+			peers = append(peers, peer)
+		}
+
+		if got := client.peerShare(peers[0], tt.remaining); got != tt.want {
+			t.Errorf("%s: peerShare() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSchedulerNextBatchHonoursShare(t *testing.T) {
+	sched := newScheduler(&SequentialPicker{PieceCount: 10}, NewBitfield(10), 10)
+
+	all := fullBitfield(10)
+
+	for _, share := range []int{3, 1, 4} {
+		if batch := sched.nextBatch(all, share, nil); len(batch) != share {
+			t.Fatalf("nextBatch(%d) = %v, want %d pieces", share, batch, share)
+		}
+	}
+
+	// Only two pieces are left unclaimed.
+	if batch := sched.nextBatch(all, 4, nil); len(batch) != 2 {
+		t.Fatalf("nextBatch(4) = %v, want the 2 remaining pieces", batch)
+	}
+}
+
+func TestDownloadSpreadsWorkAcrossPeers(t *testing.T) {
+	const pieceLen = 16 * 1024
+
+	data := testData(32 * pieceLen)
+
+	var mu sync.Mutex
+
+	served := make(map[int]map[int]bool)
+
+	// record notes which pieces each of the three peers was asked for.
+	record := func(peer int) func(index, begin, length int) bool {
+		return func(index, begin, length int) bool {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if served[peer] == nil {
+				served[peer] = make(map[int]bool)
+			}
+
+			served[peer][index] = true
+
+			return true
+		}
+	}
+
+	peers := []*testPeer{{serve: record(0)}, {serve: record(1)}, {serve: record(2)}}
+
+	client := startSwarm(t, data, pieceLen, peers)
+
+	if err := client.Download(filepath.Join(t.TempDir(), "out")); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for peer := range peers {
+		if len(served[peer]) == 0 {
+			t.Fatalf("peer %d was never given work (pieces per peer: %v)", peer, served)
+		}
+	}
+}
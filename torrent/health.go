@@ -0,0 +1,87 @@
+package torrent
+
+type SwarmHealth struct {
+	Seeders  int
+	Leechers int
+	// Available reports whether at least one complete copy of the torrent
+	// likely exists in the swarm.
+	Available bool
+	// FromScrape is false when the tracker couldn't be scraped and the
+	// numbers came from the last announce or the connected peers' bitfields.
+	FromScrape bool
+}
+
+// SwarmHealth estimates whether the torrent is downloadable. It prefers the
+// tracker's scrape statistics, then the counts from the last announce, and
+// finally the bitfields of the peers we are currently connected to.
+func (client *TorrentClient) SwarmHealth() (SwarmHealth, error) {
+	fromPeers := client.peerSwarmHealth()
+
+	result, err := client.Scrape()
+	if err != nil {
+		if counts := client.announceCounts.Load(); counts != nil {
+			return SwarmHealth{
+				Seeders:   counts.Complete,
+				Leechers:  counts.Incomplete,
+				Available: counts.Complete > 0 || fromPeers.Available,
+			}, nil
+		}
+
+		if fromPeers.Seeders+fromPeers.Leechers == 0 {
+			return SwarmHealth{}, err
+		}
+
+		return fromPeers, nil
+	}
+
+	return SwarmHealth{
+		Seeders:    result.Complete,
+		Leechers:   result.Incomplete,
+		Available:  result.Complete > 0 || fromPeers.Available,
+		FromScrape: true,
+	}, nil
+}
+
+func (client *TorrentClient) peerSwarmHealth() SwarmHealth {
+	peers := client.peerManager.all()
+
+	pieceCount := client.pieceCount()
+	combined := NewBitfield(pieceCount)
+
+	var health SwarmHealth
+
+	for _, peer := range peers {
+		complete := true
+
+		available := peer.pieces()
+
+		for i := 0; i < pieceCount; i++ {
+			if available.HasPiece(i) {
+				combined.SetPiece(i)
+			} else {
+				complete = false
+			}
+		}
+
+		if complete {
+			health.Seeders++
+		} else {
+			health.Leechers++
+		}
+	}
+
+	health.Available = health.Seeders > 0
+
+	if !health.Available && len(peers) > 0 {
+		health.Available = true
+
+		for i := 0; i < pieceCount; i++ {
+			if !combined.HasPiece(i) {
+				health.Available = false
+				break
+			}
+		}
+	}
+
+	return health
+}
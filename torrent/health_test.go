@@ -0,0 +1,126 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/decoder"
+)
+
+func TestSwarmHealthFromScrape(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   func(infoHash [20]byte) map[string]any
+		status  int
+		want    SwarmHealth
+		wantErr bool
+	}{
+		{
+			name: "seeded",
+			files: func(infoHash [20]byte) map[string]any {
+				return map[string]any{string(infoHash[:]): map[string]any{"complete": 5, "incomplete": 2, "downloaded": 40}}
+			},
+			want: SwarmHealth{Seeders: 5, Leechers: 2, Available: true, FromScrape: true},
+		},
+		{
+			name: "leechers only",
+			files: func(infoHash [20]byte) map[string]any {
+				return map[string]any{string(infoHash[:]): map[string]any{"complete": 0, "incomplete": 4, "downloaded": 9}}
+			},
+			want: SwarmHealth{Leechers: 4, FromScrape: true},
+		},
+		{
+			name: "other torrents only",
+			files: func(infoHash [20]byte) map[string]any {
+				return map[string]any{string(make([]byte, 20)): map[string]any{"complete": 3}}
+			},
+			wantErr: true,
+		},
+		{
+			name:    "scrape error status",
+			status:  http.StatusNotFound,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		var infoHash [20]byte
+
+		mux := http.NewServeMux()
+
+		mux.HandleFunc("/scrape", func(w http.ResponseWriter, r *http.Request) {
+			if tt.status != 0 {
+				w.WriteHeader(tt.status)
+				return
+			}
+
+			if got := r.URL.Query().Get("info_hash"); got != string(infoHash[:]) {
+				t.Errorf("%s: scraped info_hash %x, want %x", tt.name, got, infoHash)
+			}
+
+			resp, _ := decoder.Encode(map[string]any{"files": tt.files(infoHash)})
+			w.Write(resp)
+		})
+
+		tracker := httptest.NewServer(mux)
+
+		path := writeTestTorrent(t, testTorrent(testData(1024), 1024), tracker.URL+"/announce")
+
+		client, err := NewTorrentClient(path, WithDHTBootstrap(nil))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		infoHash = client.InfoHash
+
+		health, err := client.SwarmHealth()
+
+		tracker.Close()
+		client.Close()
+
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: SwarmHealth() = %+v, want an error", tt.name, health)
+			}
+
+			continue
+		}
+
+		if err != nil || health != tt.want {
+			t.Errorf("%s: SwarmHealth() = %+v, %v, want %+v", tt.name, health, err, tt.want)
+		}
+	}
+}
+
+func TestSwarmHealthWithoutScrapeSupport(t *testing.T) {
+	// A UDP tracker has no HTTP scrape url, so the estimate comes from the
+	// connected peers' bitfields.
+	client := newTestClient(t, testData(4*1024), 1024)
+	client.File.Announce = "udp://127.0.0.1:1/announce"
+
+	if _, err := client.SwarmHealth(); !errors.Is(err, ErrScrapeUnsupported) {
+		t.Fatalf("SwarmHealth() with no peers = %v, want ErrScrapeUnsupported", err)
+	}
+
+	for i, pieces := range [][]int{{0, 1}, {2, 3}} {
+		conn, _ := net.Pipe()
+		addr := fmt.Sprintf("192.0.2.%d:6881", i+1)
+
+		client.peerManager.add(&peerConn{addr: addr, conn: conn, bitfield: bitfieldOf(4, pieces...)})
+	}
+
+	health, err := client.SwarmHealth()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (SwarmHealth{Leechers: 2, Available: true}); health != want {
+		t.Fatalf("SwarmHealth() = %+v, want %+v", health, want)
+	}
+}
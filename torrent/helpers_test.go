@@ -0,0 +1,263 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/decoder"
+)
+
+// testPeer is a minimal seeder for download tests. It has every piece,
+// unchokes on interested and answers requests from data.
+type testPeer struct {
+	// serve, if set, decides each request: returning false drops the
+	// connection instead of sending the block.
+	serve func(index, begin, length int) bool
+
+	// corrupt flips a byte of every block sent.
+	corrupt bool
+
+	// garbage is written once, in place of the first block requested, to
+	// break the message framing.
+	garbage []byte
+	garbled atomic.Bool
+
+	// conns counts the connections accepted.
+	conns atomic.Int32
+
+	// missing pieces are left out of the bitfield and announced with a have
+	// message after the first block is served.
+	missing []int
+
+	data     []byte
+	pieceLen int
+	infoHash [20]byte
+
+	// addr is where startSwarm listens for the peer.
+	addr string
+}
+
+func (p *testPeer) run(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+
+		if err != nil {
+			return
+		}
+
+		go p.handle(conn)
+	}
+}
+
+func (p *testPeer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	p.conns.Add(1)
+
+	handshake := make([]byte, 68)
+
+	if _, err := io.ReadFull(conn, handshake); err != nil {
+		return
+	}
+
+	reply := append([]byte{19}, protocolString...)
+	reply = append(reply, make([]byte, 8)...)
+	reply = append(reply, p.infoHash[:]...)
+	reply = append(reply, "-TS0001-testpeer0000"...)
+
+	if _, err := conn.Write(reply); err != nil {
+		return
+	}
+
+	pieceCount := (len(p.data) + p.pieceLen - 1) / p.pieceLen
+
+	bitfield := fullBitfield(pieceCount)
+
+	for _, index := range p.missing {
+		bitfield.ClearPiece(index)
+	}
+
+	if err := writeMessage(conn, MsgBitfield, bitfield); err != nil {
+		return
+	}
+
+	announced := len(p.missing) == 0
+
+	for {
+		msg, err := readMessage(conn)
+
+		if err != nil {
+			return
+		}
+
+		if msg == nil {
+			continue
+		}
+
+		switch msg.ID {
+		case MsgInterested:
+			if err := writeMessage(conn, MsgUnchoke, nil); err != nil {
+				return
+			}
+		case MsgRequest:
+			index := int(binary.BigEndian.Uint32(msg.Payload[0:4]))
+			begin := int(binary.BigEndian.Uint32(msg.Payload[4:8]))
+			length := int(binary.BigEndian.Uint32(msg.Payload[8:12]))
+
+			if p.serve != nil && !p.serve(index, begin, length) {
+				return
+			}
+
+			if p.garbage != nil && !p.garbled.Swap(true) {
+				if _, err := conn.Write(p.garbage); err != nil {
+					return
+				}
+
+				continue
+			}
+
+			offset := index*p.pieceLen + begin
+			block := append([]byte(nil), p.data[offset:offset+length]...)
+
+			if p.corrupt {
+				block[0] ^= 0xff
+			}
+
+			payload := binary.BigEndian.AppendUint32(nil, uint32(index))
+			payload = binary.BigEndian.AppendUint32(payload, uint32(begin))
+
+			if err := writeMessage(conn, MsgPiece, append(payload, block...)); err != nil {
+				return
+			}
+
+			if !announced {
+				for _, index := range p.missing {
+					if err := writeMessage(conn, MsgHave, binary.BigEndian.AppendUint32(nil, uint32(index))); err != nil {
+						return
+					}
+				}
+
+				announced = true
+			}
+		}
+	}
+}
+
+// testTorrent builds the metainfo for data split into pieceLen pieces.
+func testTorrent(data []byte, pieceLen int) MetaInfo {
+	var pieces []byte
+
+	for i := 0; i < len(data); i += pieceLen {
+		hash := sha1.Sum(data[i:min(i+pieceLen, len(data))])
+		pieces = append(pieces, hash[:]...)
+	}
+
+	return MetaInfo{Name: "test", Length: len(data), PieceLength: int64(pieceLen), Pieces: string(pieces)}
+}
+
+// writeTestTorrent saves a .torrent for info in a temporary directory.
+func writeTestTorrent(t *testing.T, info MetaInfo, announce string) string {
+	t.Helper()
+
+	encoded, err := decoder.Encode(map[string]any{
+		"announce": announce,
+		"info":     info.dict(),
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "test.torrent")
+
+	if err := os.WriteFile(path, encoded, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// startSwarm serves data from peers behind a test tracker and returns a
+// client for the torrent.
+func startSwarm(t *testing.T, data []byte, pieceLen int, peers []*testPeer, opts ...Option) *TorrentClient {
+	t.Helper()
+
+	var compact []byte
+
+	listeners := make([]net.Listener, len(peers))
+
+	for i := range peers {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() { ln.Close() })
+
+		listeners[i] = ln
+
+		addr := ln.Addr().(*net.TCPAddr)
+		compact = append(compact, addr.IP.To4()...)
+		compact = binary.BigEndian.AppendUint16(compact, uint16(addr.Port))
+	}
+
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp, _ := decoder.Encode(map[string]any{"interval": 60, "peers": string(compact)})
+		w.Write(resp)
+	}))
+
+	t.Cleanup(tracker.Close)
+
+	path := writeTestTorrent(t, testTorrent(data, pieceLen), tracker.URL+"/announce")
+
+	client, err := NewTorrentClient(path, append([]Option{WithDHTBootstrap(nil)}, opts...)...)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, peer := range peers {
+		peer.data = data
+		peer.pieceLen = pieceLen
+		peer.infoHash = client.InfoHash
+		peer.addr = listeners[i].Addr().String()
+
+		go peer.run(listeners[i])
+	}
+
+	return client
+}
+
+// testData returns n bytes that differ from piece to piece.
+func testData(n int) []byte {
+	data := make([]byte, n)
+
+	for i := range data {
+		data[i] = byte(i*7 + i/251)
+	}
+
+	return data
+}
+
+// waitFor polls cond until it holds or a few seconds pass.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
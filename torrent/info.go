@@ -0,0 +1,46 @@
+package torrent
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// PrintInfo writes a human-readable summary of the torrent to w: its name,
+// size, piece layout, trackers and info hash. It doesn't contact anyone.
+func (client *TorrentClient) PrintInfo(w io.Writer) error {
+	info := client.File.Info
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Name: %s\n", info.DisplayName())
+	fmt.Fprintf(&b, "Total Length: %d\n", client.TotalLength())
+	fmt.Fprintf(&b, "Piece Length: %d\n", info.PieceLength)
+	fmt.Fprintf(&b, "Pieces: %d\n", client.PieceCount())
+	fmt.Fprintf(&b, "Info Hash: %x\n", client.InfoHash)
+
+	if info.IsPrivate() {
+		fmt.Fprintln(&b, "Private: yes")
+	}
+
+	if client.File.Announce != "" {
+		fmt.Fprintf(&b, "Tracker URL: %s\n", client.File.Announce)
+	}
+
+	for i, tier := range client.File.AnnounceList {
+		fmt.Fprintf(&b, "Tier %d: %s\n", i+1, strings.Join(tier, " "))
+	}
+
+	if info.isMultiFile() {
+		fmt.Fprintf(&b, "Files: %d\n", len(info.Files))
+
+		for _, file := range info.Files {
+			fmt.Fprintf(&b, "  %s (%d)\n", filepath.Join(file.DisplayPath()...), file.Length)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
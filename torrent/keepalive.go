@@ -0,0 +1,79 @@
+package torrent
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// keepAliveInterval is how long a connection may go without us sending
+	// anything before a keep-alive goes out. Peers drop connections that
+	// stay silent for about two minutes.
+	keepAliveInterval = 90 * time.Second
+
+	keepAliveCheck = 10 * time.Second
+)
+
+// keepAliveConn sends keep-alives on a peer connection while it is otherwise
+// idle. Writes are serialized so a keep-alive never lands in the middle of a
+// message; every message is written with a single Write call.
+type keepAliveConn struct {
+	net.Conn
+
+	mu        sync.Mutex
+	lastWrite time.Time
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func newKeepAliveConn(conn net.Conn) *keepAliveConn {
+	c := &keepAliveConn{
+		Conn:      conn,
+		lastWrite: time.Now(),
+		done:      make(chan struct{}),
+	}
+
+	go c.run()
+
+	return c
+}
+
+func (c *keepAliveConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastWrite = time.Now()
+
+	return c.Conn.Write(b)
+}
+
+func (c *keepAliveConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+
+	return c.Conn.Close()
+}
+
+func (c *keepAliveConn) run() {
+	ticker := time.NewTicker(keepAliveCheck)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.mu.Lock()
+
+			if time.Since(c.lastWrite) >= keepAliveInterval {
+				c.lastWrite = time.Now()
+				c.Conn.Write([]byte{0, 0, 0, 0})
+			}
+
+			c.mu.Unlock()
+		case <-c.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package torrent
+
+// Logger receives the client's diagnostic messages. Implementations must be
+// safe for concurrent use.
+type Logger interface {
+	Debugf(format string, args ...any)
+	Infof(format string, args ...any)
+	Errorf(format string, args ...any)
+}
+
+type noopLogger struct{}
+
+func (noopLogger) Debugf(string, ...any) {}
+
+func (noopLogger) Infof(string, ...any) {}
+
+func (noopLogger) Errorf(string, ...any) {}
+
+// logEvent writes an event to the logger, tagged with the peer and piece it
+// concerns.
+func (client *TorrentClient) logEvent(event Event) {
+	switch event.Type {
+	case EventPeerConnected, EventPeerDisconnected:
+		client.logger.Debugf("%s: peer=%s", event.Type, event.Peer)
+	case EventPieceCompleted:
+		client.logger.Debugf("%s: peer=%s piece=%d", event.Type, event.Peer, event.Piece)
+	case EventPieceFailed:
+		client.logger.Errorf("%s: peer=%s piece=%d err=%v", event.Type, event.Peer, event.Piece, event.Err)
+	case EventPeerBanned:
+		client.logger.Infof("%s: peer=%s", event.Type, event.Peer)
+	case EventAnnounceDone:
+		client.logger.Infof("%s: peers=%d seeders=%d leechers=%d", event.Type, event.Peers, event.Seeders, event.Leechers)
+	case EventWaitingForPeers:
+		client.logger.Infof("%s: err=%v", event.Type, event.Err)
+	case EventDownloadComplete:
+		client.logger.Infof("%s", event.Type)
+	}
+}
@@ -0,0 +1,109 @@
+package torrent
+
+import (
+	"context"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+type Magnet struct {
+	InfoHash    [20]byte
+	DisplayName string
+	Trackers    []string
+}
+
+// ParseMagnet reads the info hash (hex or base32), display name and trackers
+// from a magnet URI.
+func ParseMagnet(uri string) (Magnet, error) {
+	var magnet Magnet
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return magnet, fmt.Errorf("failed to parse magnet link: %v", err)
+	}
+
+	if u.Scheme != "magnet" {
+		return magnet, fmt.Errorf("not a magnet link: %s", uri)
+	}
+
+	query := u.Query()
+
+	found := false
+
+	for _, xt := range query["xt"] {
+		encoded, ok := strings.CutPrefix(xt, "urn:btih:")
+
+		if !ok {
+			continue
+		}
+
+		var hash []byte
+
+		switch len(encoded) {
+		case 40:
+			hash, err = hex.DecodeString(encoded)
+		case 32:
+			hash, err = base32.StdEncoding.DecodeString(strings.ToUpper(encoded))
+		default:
+			err = fmt.Errorf("info hash has %d characters", len(encoded))
+		}
+
+		if err != nil {
+			return magnet, fmt.Errorf("invalid info hash in magnet link: %v", err)
+		}
+
+		copy(magnet.InfoHash[:], hash)
+		found = true
+
+		break
+	}
+
+	if !found {
+		return magnet, fmt.Errorf("magnet link has no urn:btih info hash")
+	}
+
+	magnet.DisplayName = query.Get("dn")
+	magnet.Trackers = mergeUnique(query["tr"])
+
+	return magnet, nil
+}
+
+// NewTorrentClientFromMagnet creates a client from a magnet URI. The info dict
+// isn't part of the link, so it is fetched from peers (BEP 9) before
+// returning; peers are found through the link's trackers and the DHT.
+func NewTorrentClientFromMagnet(uri string, opts ...Option) (*TorrentClient, error) {
+	magnet, err := ParseMagnet(uri)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var torrentFile TorrentFile
+
+	torrentFile.Info.Name = magnet.DisplayName
+
+	if len(magnet.Trackers) > 0 {
+		torrentFile.Announce = magnet.Trackers[0]
+
+		for _, tracker := range magnet.Trackers {
+			torrentFile.AnnounceList = append(torrentFile.AnnounceList, []string{tracker})
+		}
+	}
+
+	client, err := newTorrentClient(torrentFile, magnet.InfoHash, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := client.FetchMetadata(context.Background()); err != nil {
+		return nil, err
+	}
+
+	client.defaultPicker()
+
+	return client, nil
+}
@@ -0,0 +1,124 @@
+package torrent
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	MsgChoke         = 0
+	MsgUnchoke       = 1
+	MsgInterested    = 2
+	MsgNotInterested = 3
+	MsgHave          = 4
+	MsgBitfield      = 5
+	MsgRequest       = 6
+	MsgPiece         = 7
+	MsgCancel        = 8
+	MsgPort          = 9
+	MsgSuggestPiece  = 13
+	MsgHaveAll       = 14
+	MsgHaveNone      = 15
+	MsgRejectRequest = 16
+	MsgAllowedFast   = 17
+	MsgExtended      = 20
+)
+
+// The original message id names, kept so existing callers still build. The
+// name Bitfield now belongs to the bitfield type, so its id is only available
+// as MsgBitfield.
+//
+// Deprecated: use MsgUnchoke, MsgInterested and MsgRequest.
+const (
+	Unchoke    = MsgUnchoke
+	Interested = MsgInterested
+	Request    = MsgRequest
+)
+
+// maxMessageLength bounds the length prefix of a single peer message. The
+// largest legitimate message is a piece carrying one block (or a bitfield for
+// a very large torrent), so anything beyond this means we lost the framing.
+const maxMessageLength = 1 << 21
+
+// maxUnknownMessageLength is the largest payload we tolerate for a message id
+// we don't understand before assuming the stream is desynchronized.
+const maxUnknownMessageLength = 1 << 14
+
+var ErrDesync = errors.New("peer stream desynchronized")
+
+// ErrTruncatedMessage is returned when the stream ends in the middle of a
+// frame, as opposed to io.EOF for a peer that closed between messages.
+var ErrTruncatedMessage = errors.New("peer message truncated")
+
+func isKnownMessage(id byte) bool {
+	return id <= MsgPort || (id >= MsgSuggestPiece && id <= MsgAllowedFast) || id == MsgExtended
+}
+
+type PeerMessage struct {
+	ID      uint8
+	Payload []byte
+}
+
+// readMessage reads one length-prefixed message from the peer. Keep-alives
+// are returned as a nil message. Every peer read goes through here so short
+// reads and oversized frames are handled the same way everywhere.
+func readMessage(r io.Reader) (*PeerMessage, error) {
+	lengthBuf := make([]byte, 4)
+
+	if _, err := io.ReadFull(r, lengthBuf); err != nil {
+		return nil, readError(err)
+	}
+
+	length := binary.BigEndian.Uint32(lengthBuf)
+
+	if length == 0 {
+		return nil, nil
+	}
+
+	if length > maxMessageLength {
+		return nil, fmt.Errorf("%w: message length %d exceeds %d", ErrDesync, length, maxMessageLength)
+	}
+
+	message := make([]byte, length)
+
+	if _, err := io.ReadFull(r, message); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return nil, readError(err)
+	}
+
+	if !isKnownMessage(message[0]) && length-1 > maxUnknownMessageLength {
+		return nil, fmt.Errorf("%w: unknown message id %d with length %d", ErrDesync, message[0], length)
+	}
+
+	return &PeerMessage{ID: message[0], Payload: message[1:]}, nil
+}
+
+func readError(err error) error {
+	switch err {
+	case io.EOF:
+		return fmt.Errorf("failed to read from a peer: %w", err)
+	case io.ErrUnexpectedEOF:
+		return fmt.Errorf("failed to read from a peer: %w", ErrTruncatedMessage)
+	default:
+		return fmt.Errorf("failed to read from a peer: %v", err)
+	}
+}
+
+func writeMessage(w io.Writer, id byte, payload []byte) error {
+	buf := make([]byte, 5+len(payload))
+
+	binary.BigEndian.PutUint32(buf, uint32(1+len(payload)))
+	buf[4] = id
+	copy(buf[5:], payload)
+
+	if _, err := w.Write(buf); err != nil {
+		return fmt.Errorf("failed to write to a peer: %v", err)
+	}
+
+	return nil
+}
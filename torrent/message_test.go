@@ -0,0 +1,107 @@
+package torrent
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestDeprecatedMessageIDs(t *testing.T) {
+	tests := []struct {
+		name      string
+		got, want int
+	}{
+		{"Unchoke", Unchoke, 1},
+		{"Interested", Interested, 2},
+		{"Request", Request, 6},
+	}
+
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %d, want %d", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+// frame returns a message with the given length prefix followed by body.
+func frame(length uint32, body ...byte) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, length), body...)
+}
+
+func TestReadMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []byte
+		want    *PeerMessage
+		wantErr error
+	}{
+		{"keep-alive", frame(0), nil, nil},
+		{"no payload", frame(1, MsgUnchoke), &PeerMessage{ID: MsgUnchoke, Payload: []byte{}}, nil},
+		{"have", frame(5, MsgHave, 0, 0, 0, 7), &PeerMessage{ID: MsgHave, Payload: []byte{0, 0, 0, 7}}, nil},
+		{"closed between messages", nil, nil, io.EOF},
+		{"short prefix", []byte{0, 0}, nil, ErrTruncatedMessage},
+		{"prefix only", frame(5), nil, ErrTruncatedMessage},
+		{"short body", frame(5, MsgHave, 0, 0), nil, ErrTruncatedMessage},
+		{"oversized frame", frame(maxMessageLength + 1), nil, ErrDesync},
+		{"unknown id", frame(3, 42, 1, 2), &PeerMessage{ID: 42, Payload: []byte{1, 2}}, nil},
+		{"large unknown id", frame(maxUnknownMessageLength+2, append([]byte{42}, make([]byte, maxUnknownMessageLength+1)...)...), nil, ErrDesync},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := readMessage(bytes.NewReader(tt.input))
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("readMessage() error = %v, want %v", err, tt.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.want == nil {
+				if msg != nil {
+					t.Fatalf("readMessage() = %+v, want a keep-alive", msg)
+				}
+
+				return
+			}
+
+			if msg == nil || msg.ID != tt.want.ID || !bytes.Equal(msg.Payload, tt.want.Payload) {
+				t.Fatalf("readMessage() = %+v, want %+v", msg, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadMessageSequence(t *testing.T) {
+	var stream []byte
+	stream = append(stream, frame(0)...)
+	stream = append(stream, frame(1, MsgInterested)...)
+	stream = append(stream, frame(0)...)
+	stream = append(stream, frame(2, MsgBitfield, 0x80)...)
+
+	r := bytes.NewReader(stream)
+
+	for i, want := range []int{-1, MsgInterested, -1, MsgBitfield} {
+		msg, err := readMessage(r)
+
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+
+		if (want == -1) != (msg == nil) || (msg != nil && int(msg.ID) != want) {
+			t.Fatalf("message %d = %+v, want id %d", i, msg, want)
+		}
+	}
+
+	if _, err := readMessage(r); !errors.Is(err, io.EOF) {
+		t.Fatalf("readMessage() at end = %v, want io.EOF", err)
+	}
+}
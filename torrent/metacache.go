@@ -0,0 +1,90 @@
+package torrent
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// FetchMetadata makes sure the client has the torrent's info dict, fetching
+// it from peers over BEP 9 if needed. With WithMetadataCache the info dict is
+// read from the cache when present and written there after a fetch, so
+// repeated runs don't contact peers again.
+func (client *TorrentClient) FetchMetadata(ctx context.Context) error {
+	if client.File.rawInfo != nil {
+		return nil
+	}
+
+	ctx, cancel := client.untilClosed(ctx)
+	defer cancel()
+
+	if client.loadCachedInfo() == nil {
+		return nil
+	}
+
+	if len(client.knownPeers()) == 0 {
+		if err := client.discoverPeers(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err := client.fetchMetadata(ctx); err != nil {
+		return err
+	}
+
+	if client.metadataCacheDir == "" {
+		return nil
+	}
+
+	return client.SaveInfo(client.metadataCachePath())
+}
+
+// SaveInfo writes the raw info dict to path. LoadInfo reads it back.
+func (client *TorrentClient) SaveInfo(path string) error {
+	if client.File.rawInfo == nil {
+		return fmt.Errorf("no info dict to save")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), client.dirMode); err != nil {
+		return fmt.Errorf("failed to create cache directory: %v", err)
+	}
+
+	tmp := path + ".tmp"
+
+	if err := os.WriteFile(tmp, client.File.rawInfo, 0644); err != nil {
+		return fmt.Errorf("failed to save info dict: %v", err)
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// LoadInfo installs an info dict saved with SaveInfo. It fails if the dict
+// doesn't belong to this torrent's info hash.
+func (client *TorrentClient) LoadInfo(path string) error {
+	rawInfo, err := os.ReadFile(path)
+
+	if err != nil {
+		return fmt.Errorf("failed to load info dict: %v", err)
+	}
+
+	if sha1.Sum(rawInfo) != client.InfoHash {
+		return fmt.Errorf("%w: cached info hash mismatch", ErrBadMetadata)
+	}
+
+	return client.setInfo(rawInfo)
+}
+
+func (client *TorrentClient) metadataCachePath() string {
+	return filepath.Join(client.metadataCacheDir, hex.EncodeToString(client.InfoHash[:])+".info")
+}
+
+func (client *TorrentClient) loadCachedInfo() error {
+	if client.metadataCacheDir == "" {
+		return fmt.Errorf("no metadata cache")
+	}
+
+	return client.LoadInfo(client.metadataCachePath())
+}
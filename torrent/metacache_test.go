@@ -0,0 +1,120 @@
+package torrent
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/decoder"
+)
+
+func TestFetchMetadataUsesCache(t *testing.T) {
+	info := testTorrent(testData(3*1024+10), 1024)
+
+	rawInfo, err := decoder.Encode(info.dict())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	infoHash := sha1.Sum(rawInfo)
+
+	var announces atomic.Int32
+
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		announces.Add(1)
+
+		resp, _ := decoder.Encode(map[string]any{"interval": 60, "peers": ""})
+		w.Write(resp)
+	}))
+	defer tracker.Close()
+
+	magnet := "magnet:?xt=urn:btih:" + hex.EncodeToString(infoHash[:]) + "&tr=" + url.QueryEscape(tracker.URL+"/announce")
+
+	cache := t.TempDir()
+
+	// The constructor fetches the metadata.
+	newClient := func() (*TorrentClient, error) {
+		return NewTorrentClientFromMagnet(magnet, WithDHTBootstrap(nil), WithMetadataCache(cache), WithPeerDiscovery(10*time.Millisecond, 50*time.Millisecond))
+	}
+
+	// Without a cached copy the peers have to be asked, and there are none.
+	if _, err := newClient(); err == nil {
+		t.Fatal("metadata fetched without peers or a cache")
+	}
+
+	if announces.Load() == 0 {
+		t.Fatal("tracker was not contacted for an uncached torrent")
+	}
+
+	cachePath := filepath.Join(cache, hex.EncodeToString(infoHash[:])+".info")
+
+	if err := os.WriteFile(cachePath, rawInfo, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	announces.Store(0)
+
+	client, err := newClient()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer client.Close()
+
+	// Already loaded, so this is a no-op.
+	if err := client.FetchMetadata(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if announces.Load() != 0 {
+		t.Fatalf("tracker contacted %d times despite a cached info dict", announces.Load())
+	}
+
+	if client.File.Info.Pieces != info.Pieces || client.PieceCount() != 4 {
+		t.Fatalf("info from the cache = %+v, want %+v", client.File.Info, info)
+	}
+}
+
+func TestLoadInfoRejectsOtherTorrents(t *testing.T) {
+	client := newTestClient(t, testData(1024), 1024)
+
+	other, err := decoder.Encode(testTorrent(testData(2048), 1024).dict())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "other.info")
+
+	if err := os.WriteFile(path, other, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.LoadInfo(path); err == nil {
+		t.Fatal("LoadInfo() accepted the info dict of another torrent")
+	}
+}
+
+func TestSaveInfoRoundTrip(t *testing.T) {
+	client := newTestClient(t, testData(1024), 1024)
+
+	path := filepath.Join(t.TempDir(), "nested", "saved.info")
+
+	if err := client.SaveInfo(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.LoadInfo(path); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,300 @@
+package torrent
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/decoder"
+)
+
+// ourReserved are the reserved handshake bytes we send. Bit 20 from the
+// right (0x10 in byte 5) advertises the BEP 10 extension protocol and 0x04 in
+// byte 7 the BEP 6 fast extension.
+var ourReserved = [8]byte{0, 0, 0, 0, 0, 0x10, 0, 0x04}
+
+func supportsExtensions(reserved [8]byte) bool {
+	return reserved[5]&0x10 != 0
+}
+
+func supportsFast(reserved [8]byte) bool {
+	return reserved[7]&0x04 != 0
+}
+
+const (
+	extHandshakeID = 0
+	// utMetadataID is the id we ask peers to use for ut_metadata messages
+	// sent to us.
+	utMetadataID = 1
+
+	metadataPieceSize = 16 * 1024
+	maxMetadataSize   = 8 * 1024 * 1024
+
+	metadataRequest = 0
+	metadataData    = 1
+	metadataReject  = 2
+)
+
+var ErrBadMetadata = errors.New("peer sent invalid metadata")
+
+type extHandshake struct {
+	// M maps extension names to the ids the peer wants us to use.
+	M            map[string]int
+	MetadataSize int
+}
+
+func parseExtHandshake(payload []byte) (*extHandshake, error) {
+	decoded, err := decoder.New(payload).Decode()
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode extended handshake: %v", err)
+	}
+
+	dict, ok := decoded.(map[string]any)
+
+	if !ok {
+		return nil, fmt.Errorf("extended handshake is not a dict")
+	}
+
+	hs := &extHandshake{M: make(map[string]int)}
+
+	if m, ok := dict["m"].(map[string]any); ok {
+		for name, id := range m {
+			if n, ok := id.(int); ok {
+				hs.M[name] = n
+			}
+		}
+	}
+
+	if size, ok := dict["metadata_size"].(int); ok {
+		hs.MetadataSize = size
+	}
+
+	return hs, nil
+}
+
+// sendExtHandshake sends our BEP 10 handshake, advertising the extensions in
+// m under the ids we want peers to use.
+func sendExtHandshake(conn net.Conn, m map[string]any) error {
+	payload, err := decoder.Encode(map[string]any{"m": m})
+
+	if err != nil {
+		return err
+	}
+
+	return writeMessage(conn, MsgExtended, append([]byte{extHandshakeID}, payload...))
+}
+
+// fetchMetadata downloads the info dict from the known peers with BEP 9 and
+// installs it on the client. Peers whose metadata doesn't match the size they
+// advertised or the info hash are skipped.
+func (client *TorrentClient) fetchMetadata(ctx context.Context) error {
+	var lastErr error
+
+	for _, addr := range client.knownPeers() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		info, err := client.fetchMetadataFrom(ctx, addr)
+
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		return client.setInfo(info)
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no peers to fetch metadata from")
+	}
+
+	return fmt.Errorf("failed to fetch metadata: %w", lastErr)
+}
+
+func (client *TorrentClient) fetchMetadataFrom(ctx context.Context, addr string) ([]byte, error) {
+	conn, reserved, err := client.handshake(addr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	// Closing the connection unblocks any pending read once ctx is done.
+	defer context.AfterFunc(ctx, func() { conn.Close() })()
+
+	if !supportsExtensions(reserved) {
+		return nil, fmt.Errorf("peer %s does not support extensions", addr)
+	}
+
+	if err := sendExtHandshake(conn, map[string]any{"ut_metadata": utMetadataID}); err != nil {
+		return nil, err
+	}
+
+	var hs *extHandshake
+
+	for hs == nil {
+		msg, err := client.readPeerMessage(conn)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if msg == nil || msg.ID != MsgExtended || len(msg.Payload) == 0 || msg.Payload[0] != extHandshakeID {
+			continue
+		}
+
+		if hs, err = parseExtHandshake(msg.Payload[1:]); err != nil {
+			return nil, err
+		}
+	}
+
+	peerMetadataID, ok := hs.M["ut_metadata"]
+
+	if !ok || peerMetadataID == 0 {
+		return nil, fmt.Errorf("peer %s does not support ut_metadata", addr)
+	}
+
+	if hs.MetadataSize <= 0 || hs.MetadataSize > maxMetadataSize {
+		return nil, fmt.Errorf("%w: advertised metadata_size %d", ErrBadMetadata, hs.MetadataSize)
+	}
+
+	pieceCount := (hs.MetadataSize + metadataPieceSize - 1) / metadataPieceSize
+
+	metadata := make([]byte, 0, hs.MetadataSize)
+
+	for piece := 0; piece < pieceCount; piece++ {
+		request, err := decoder.Encode(map[string]any{
+			"msg_type": metadataRequest,
+			"piece":    piece,
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := writeMessage(conn, MsgExtended, append([]byte{byte(peerMetadataID)}, request...)); err != nil {
+			return nil, err
+		}
+
+		data, err := client.readMetadataPiece(conn, piece, hs.MetadataSize)
+
+		if err != nil {
+			return nil, err
+		}
+
+		metadata = append(metadata, data...)
+	}
+
+	if err := client.validateMetadata(metadata, hs.MetadataSize); err != nil {
+		return nil, fmt.Errorf("peer %s: %w", addr, err)
+	}
+
+	return metadata, nil
+}
+
+func (client *TorrentClient) readMetadataPiece(conn net.Conn, piece, totalSize int) ([]byte, error) {
+	for {
+		msg, err := client.readPeerMessage(conn)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if msg == nil || msg.ID != MsgExtended || len(msg.Payload) == 0 || msg.Payload[0] != utMetadataID {
+			continue
+		}
+
+		header, data, err := decoder.SplitValue(msg.Payload[1:])
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadMetadata, err)
+		}
+
+		decoded, err := decoder.New(header).Decode()
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadMetadata, err)
+		}
+
+		dict, ok := decoded.(map[string]any)
+
+		if !ok {
+			return nil, fmt.Errorf("%w: message is not a dict", ErrBadMetadata)
+		}
+
+		msgType, _ := dict["msg_type"].(int)
+		index, _ := dict["piece"].(int)
+
+		if msgType == metadataReject {
+			return nil, fmt.Errorf("peer rejected metadata piece %d", piece)
+		}
+
+		if msgType != metadataData || index != piece {
+			continue
+		}
+
+		if size, ok := dict["total_size"].(int); ok && size != totalSize {
+			return nil, fmt.Errorf("%w: total_size %d differs from advertised %d", ErrBadMetadata, size, totalSize)
+		}
+
+		expected := metadataPieceSize
+		if rest := totalSize - piece*metadataPieceSize; rest < expected {
+			expected = rest
+		}
+
+		if len(data) != expected {
+			return nil, fmt.Errorf("%w: piece %d has %d bytes, expected %d", ErrBadMetadata, piece, len(data), expected)
+		}
+
+		return data, nil
+	}
+}
+
+// validateMetadata checks the reassembled info dict against the size the
+// peer advertised and the info hash we are looking for.
+func (client *TorrentClient) validateMetadata(metadata []byte, advertisedSize int) error {
+	if len(metadata) != advertisedSize {
+		return fmt.Errorf("%w: got %d bytes, advertised %d", ErrBadMetadata, len(metadata), advertisedSize)
+	}
+
+	if sha1.Sum(metadata) != client.InfoHash {
+		return fmt.Errorf("%w: info hash mismatch", ErrBadMetadata)
+	}
+
+	return nil
+}
+
+// setInfo installs a verified info dict on the client.
+func (client *TorrentClient) setInfo(rawInfo []byte) error {
+	var info MetaInfo
+
+	if err := decoder.Unmarshal(rawInfo, &info); err != nil {
+		return fmt.Errorf("failed to decode info dict: %v", err)
+	}
+
+	if err := info.captureExtra(rawInfo); err != nil {
+		return fmt.Errorf("failed to decode info dict: %v", err)
+	}
+
+	if err := info.validateLayout(); err != nil {
+		return fmt.Errorf("invalid info dict: %v", err)
+	}
+
+	if err := info.validatePieces(); err != nil {
+		return fmt.Errorf("invalid info dict: %v", err)
+	}
+
+	if err := info.validatePaths(); err != nil {
+		return fmt.Errorf("invalid info dict: %w", err)
+	}
+
+	client.File.Info = info
+	client.File.rawInfo = rawInfo
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package torrent
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/decoder"
+)
+
+// metadataPeer serves metadata over ut_metadata to every connection it
+// accepts, announcing infoHash in its handshake.
+type metadataPeer struct {
+	infoHash [20]byte
+	metadata []byte
+	conns    atomic.Int32
+}
+
+func (p *metadataPeer) start(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+
+			if err != nil {
+				return
+			}
+
+			go p.handle(conn)
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func (p *metadataPeer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	p.conns.Add(1)
+
+	if _, err := io.ReadFull(conn, make([]byte, 68)); err != nil {
+		return
+	}
+
+	const peerMetadataID = 3
+
+	reply := append([]byte{19}, protocolString...)
+	reply = append(reply, 0, 0, 0, 0, 0, 0x10, 0, 0)
+	reply = append(reply, p.infoHash[:]...)
+	reply = append(reply, "-TS0001-testpeer0000"...)
+
+	hs, _ := decoder.Encode(map[string]any{
+		"m":             map[string]any{"ut_metadata": peerMetadataID},
+		"metadata_size": len(p.metadata),
+	})
+
+	if _, err := conn.Write(reply); err != nil {
+		return
+	}
+
+	if err := writeMessage(conn, MsgExtended, append([]byte{extHandshakeID}, hs...)); err != nil {
+		return
+	}
+
+	for {
+		msg, err := readMessage(conn)
+
+		if err != nil {
+			return
+		}
+
+		if msg == nil || msg.ID != MsgExtended || len(msg.Payload) == 0 || msg.Payload[0] != peerMetadataID {
+			continue
+		}
+
+		decoded, err := decoder.New(msg.Payload[1:]).Decode()
+
+		if err != nil {
+			return
+		}
+
+		piece, _ := decoded.(map[string]any)["piece"].(int)
+
+		start := piece * metadataPieceSize
+		end := min(start+metadataPieceSize, len(p.metadata))
+
+		header, _ := decoder.Encode(map[string]any{"msg_type": metadataData, "piece": piece, "total_size": len(p.metadata)})
+
+		payload := append([]byte{utMetadataID}, header...)
+
+		if err := writeMessage(conn, MsgExtended, append(payload, p.metadata[start:end]...)); err != nil {
+			return
+		}
+	}
+}
+
+func TestFetchMetadataSkipsPeerWithWrongHash(t *testing.T) {
+	info := testTorrent(testData(3*1024+10), 1024)
+	info.Name = strings.Repeat("n", 20*1024) // two metadata pieces
+
+	rawInfo, err := decoder.Encode(info.dict())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	infoHash := sha1.Sum(rawInfo)
+
+	// Same size as the real metadata, so only the hash gives it away.
+	fake := append([]byte(nil), rawInfo...)
+	fake[len(fake)-2] ^= 0xff
+
+	bad := &metadataPeer{infoHash: infoHash, metadata: fake}
+	good := &metadataPeer{infoHash: infoHash, metadata: rawInfo}
+
+	client, err := newTorrentClient(TorrentFile{}, infoHash, []Option{WithDHTBootstrap(nil)})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer client.Close()
+
+	client.Peers = []string{bad.start(t), good.start(t)}
+
+	if _, err := client.fetchMetadataFrom(context.Background(), client.Peers[0]); !errors.Is(err, ErrBadMetadata) {
+		t.Fatalf("fetchMetadataFrom(bad peer) = %v, want ErrBadMetadata", err)
+	}
+
+	if err := client.fetchMetadata(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if bad.conns.Load() < 2 || good.conns.Load() != 1 {
+		t.Fatalf("bad peer asked %d times and good peer %d, want both asked", bad.conns.Load(), good.conns.Load())
+	}
+
+	if client.File.Info.Name != info.Name || client.File.Info.Pieces != info.Pieces || string(client.File.rawInfo) != string(rawInfo) {
+		t.Fatal("installed info dict is not the good peer's")
+	}
+}
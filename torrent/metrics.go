@@ -0,0 +1,78 @@
+package torrent
+
+import (
+	"errors"
+	"sync"
+)
+
+// Metric names reported by the client. Counters only ever grow; gauges are
+// set to the current value.
+const (
+	MetricPiecesCompleted     = "pieces_completed_total"
+	MetricBytesDownloaded     = "bytes_downloaded_total"
+	MetricBytesUploaded       = "bytes_uploaded_total"
+	MetricFailedVerifications = "failed_verifications_total"
+	MetricPiecesRehashed      = "pieces_rehashed_total"
+	MetricAnnounceErrors      = "announce_errors_total"
+	MetricActivePeers         = "active_peers"
+)
+
+// Metrics receives the client's counters and gauges, e.g. to expose them to
+// Prometheus. Implementations must be safe for concurrent use.
+type Metrics interface {
+	AddCounter(name string, delta int64)
+	SetGauge(name string, value int64)
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) AddCounter(string, int64) {}
+
+func (noopMetrics) SetGauge(string, int64) {}
+
+// MemoryMetrics keeps the latest values in memory.
+type MemoryMetrics struct {
+	mu     sync.Mutex
+	values map[string]int64
+}
+
+func NewMemoryMetrics() *MemoryMetrics {
+	return &MemoryMetrics{values: make(map[string]int64)}
+}
+
+func (m *MemoryMetrics) AddCounter(name string, delta int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.values[name] += delta
+}
+
+func (m *MemoryMetrics) SetGauge(name string, value int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.values[name] = value
+}
+
+// Value returns the current value of a counter or gauge.
+func (m *MemoryMetrics) Value(name string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.values[name]
+}
+
+// recordMetrics updates the metrics that follow from an event, so they move
+// in step with what Events subscribers see.
+func (client *TorrentClient) recordMetrics(event Event) {
+	switch event.Type {
+	case EventPieceCompleted:
+		client.metrics.AddCounter(MetricPiecesCompleted, 1)
+	case EventPieceFailed:
+		if errors.Is(event.Err, ErrPieceHashMismatch) {
+			client.metrics.AddCounter(MetricFailedVerifications, 1)
+		}
+	case EventPeerConnected, EventPeerDisconnected:
+		client.metrics.SetGauge(MetricActivePeers, int64(client.peerManager.count()))
+	}
+}
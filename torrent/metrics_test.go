@@ -0,0 +1,84 @@
+package torrent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/decoder"
+)
+
+func TestMetricsAdvanceDuringDownload(t *testing.T) {
+	data := testData(6*16*1024 + 10)
+
+	metrics := NewMemoryMetrics()
+
+	client := startSwarm(t, data, 16*1024, []*testPeer{{}, {}}, WithMetrics(metrics))
+
+	if err := client.Download(filepath.Join(t.TempDir(), "out")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := metrics.Value(MetricPiecesCompleted), int64(client.PieceCount()); got != want {
+		t.Errorf("%s = %d, want %d", MetricPiecesCompleted, got, want)
+	}
+
+	if got := metrics.Value(MetricBytesDownloaded); got < int64(len(data)) {
+		t.Errorf("%s = %d, want at least %d", MetricBytesDownloaded, got, len(data))
+	}
+}
+
+func TestMetricsCountUploadedBytes(t *testing.T) {
+	data := testData(3*16*1024 + 10)
+
+	var seeder *TorrentClient
+
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peers := []any{map[string]any{"ip": "127.0.0.1", "port": seeder.Port()}}
+		resp, _ := decoder.Encode(map[string]any{"interval": 60, "peers": peers})
+		w.Write(resp)
+	}))
+	defer tracker.Close()
+
+	path := writeTestTorrent(t, testTorrent(data, 16*1024), tracker.URL+"/announce")
+
+	dir := t.TempDir()
+	seeded := filepath.Join(dir, "test")
+
+	if err := os.WriteFile(seeded, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	metrics := NewMemoryMetrics()
+
+	seeder, err := NewTorrentClient(path, WithPort(0), WithMetrics(metrics))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer seeder.Close()
+
+	go seeder.Seed(seeded)
+
+	for deadline := time.Now().Add(5 * time.Second); seeder.Port() == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	leecher, err := NewTorrentClient(path, WithDHTBootstrap(nil))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := leecher.Download(filepath.Join(dir, "out")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := metrics.Value(MetricBytesUploaded); got != int64(len(data)) {
+		t.Fatalf("%s = %d, want %d", MetricBytesUploaded, got, len(data))
+	}
+}
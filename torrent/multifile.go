@@ -0,0 +1,282 @@
+package torrent
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// fileSpan places one file of the torrent in the concatenated piece stream.
+type fileSpan struct {
+	path   string
+	offset int64
+	length int64
+}
+
+func (m MetaInfo) isMultiFile() bool {
+	return len(m.Files) > 0
+}
+
+// totalLength is the size of the piece stream: the single file's length, or
+// the sum of every file in a multi-file torrent.
+func (m MetaInfo) totalLength() int {
+	if !m.isMultiFile() {
+		return m.Length
+	}
+
+	total := 0
+
+	for _, file := range m.Files {
+		total += file.Length
+	}
+
+	return total
+}
+
+// validateLayout rejects torrents that set both length and files, neither of
+// them, or a negative file length.
+func (m MetaInfo) validateLayout() error {
+	if m.isMultiFile() && m.Length != 0 {
+		return fmt.Errorf("info dict has both length and files")
+	}
+
+	if !m.isMultiFile() && m.Length <= 0 {
+		return fmt.Errorf("info dict has neither a positive length nor files")
+	}
+
+	for _, file := range m.Files {
+		if file.Length < 0 {
+			return fmt.Errorf("file %v has negative length %d", file.Path, file.Length)
+		}
+	}
+
+	return nil
+}
+
+// fileLayout maps the torrent's files onto disk. A single-file torrent is
+// written to output itself; a multi-file torrent gets a directory named after
+// the torrent inside output.
+func (client *TorrentClient) fileLayout(output string) ([]fileSpan, error) {
+	info := client.File.Info
+
+	if !info.isMultiFile() {
+		return []fileSpan{{path: output, length: int64(info.Length)}}, nil
+	}
+
+	root, err := filePath(output, []string{info.DisplayName()})
+
+	if err != nil {
+		return nil, err
+	}
+
+	spans := make([]fileSpan, 0, len(info.Files))
+
+	var offset int64
+
+	for _, file := range info.Files {
+		path, err := filePath(root, file.DisplayPath())
+
+		if err != nil {
+			return nil, err
+		}
+
+		spans = append(spans, fileSpan{path: path, offset: offset, length: int64(file.Length)})
+		offset += int64(file.Length)
+	}
+
+	return spans, nil
+}
+
+// eachSpan calls fn for every part of [offset, offset+n) that falls in a
+// file, with the position inside that file and inside the range. A range
+// crossing a file boundary is split between the files.
+func eachSpan(spans []fileSpan, offset, n int64, fn func(i int, fileOffset, rangeOffset, length int64) error) error {
+	end := offset + n
+
+	for i, span := range spans {
+		start := max(offset, span.offset)
+		stop := min(end, span.offset+span.length)
+
+		if start >= stop {
+			continue
+		}
+
+		if err := fn(i, start-span.offset, start-offset, stop-start); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// multiFileStorage writes the piece stream across the files of a multi-file
+// torrent.
+type multiFileStorage struct {
+	spans       []fileSpan
+	files       []*os.File
+	pieceLength int64
+}
+
+func newMultiFileStorage(spans []fileSpan, pieceLength int64, truncate bool, dirMode os.FileMode) (*multiFileStorage, error) {
+	s := &multiFileStorage{spans: spans, pieceLength: pieceLength}
+
+	flags := os.O_RDWR | os.O_CREATE
+
+	if truncate {
+		flags |= os.O_TRUNC
+	}
+
+	for _, span := range spans {
+		if err := os.MkdirAll(filepath.Dir(span.path), dirMode); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("failed to create directory for %s: %v", span.path, err)
+		}
+
+		file, err := os.OpenFile(span.path, flags, 0644)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("failed to open output file: %v", err)
+		}
+
+		s.files = append(s.files, file)
+
+		info, err := file.Stat()
+
+		if err == nil && info.Size() < span.length {
+			err = file.Truncate(span.length)
+		}
+
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("failed to size output file: %v", err)
+		}
+	}
+
+	return s, nil
+}
+
+func (s *multiFileStorage) WriteBlock(piece, offset int, data []byte) error {
+	start := int64(piece)*s.pieceLength + int64(offset)
+
+	return eachSpan(s.spans, start, int64(len(data)), func(i int, fileOffset, rangeOffset, length int64) error {
+		if _, err := s.files[i].WriteAt(data[rangeOffset:rangeOffset+length], fileOffset); err != nil {
+			return fmt.Errorf("failed to write piece %d: %v", piece, err)
+		}
+
+		return nil
+	})
+}
+
+func (s *multiFileStorage) Sync() error {
+	for _, file := range s.files {
+		if err := file.Sync(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *multiFileStorage) Close() error {
+	var firstErr error
+
+	for _, file := range s.files {
+		if err := file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	s.files = nil
+
+	return firstErr
+}
+
+// openStorage opens the output for writing, whichever layout the torrent has.
+func (client *TorrentClient) openStorage(output string, truncate bool) (Storage, error) {
+	if client.storage != nil {
+		return client.storage(output, truncate)
+	}
+
+	info := client.File.Info
+
+	if !info.isMultiFile() {
+		return newFileStorage(output, info.PieceLength, int64(info.Length), truncate)
+	}
+
+	spans, err := client.fileLayout(output)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newMultiFileStorage(spans, info.PieceLength, truncate, client.dirMode)
+}
+
+// layoutReader reads the piece stream back from the files on disk. Missing
+// or short files read as the end of the stream.
+type layoutReader struct {
+	spans []fileSpan
+	files []*os.File
+}
+
+// openReader opens an existing (possibly partial) download for reading.
+func (client *TorrentClient) openReader(output string) (*layoutReader, error) {
+	spans, err := client.fileLayout(output)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r := &layoutReader{spans: spans, files: make([]*os.File, len(spans))}
+
+	opened := 0
+
+	for i, span := range spans {
+		if file, err := os.Open(span.path); err == nil {
+			r.files[i] = file
+			opened++
+		}
+	}
+
+	if opened == 0 && len(spans) > 0 {
+		return nil, fmt.Errorf("failed to open %s: %w", output, os.ErrNotExist)
+	}
+
+	return r, nil
+}
+
+func (r *layoutReader) ReadAt(p []byte, off int64) (int, error) {
+	read := 0
+
+	err := eachSpan(r.spans, off, int64(len(p)), func(i int, fileOffset, rangeOffset, length int64) error {
+		if r.files[i] == nil {
+			return io.EOF
+		}
+
+		n, err := r.files[i].ReadAt(p[rangeOffset:rangeOffset+length], fileOffset)
+		read += n
+
+		return err
+	})
+
+	if err != nil {
+		return read, err
+	}
+
+	if read < len(p) {
+		return read, io.EOF
+	}
+
+	return read, nil
+}
+
+func (r *layoutReader) Close() error {
+	for _, file := range r.files {
+		if file != nil {
+			file.Close()
+		}
+	}
+
+	return nil
+}
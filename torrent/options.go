@@ -0,0 +1,255 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+type Option func(*TorrentClient)
+
+var ErrInvalidPort = errors.New("port out of range")
+
+var ErrInvalidMaxPeers = errors.New("max peers is negative")
+
+// setOptionErr records the first invalid option value.
+func (client *TorrentClient) setOptionErr(err error) {
+	if client.optionErr == nil {
+		client.optionErr = err
+	}
+}
+
+func WithPiecePicker(picker PiecePicker) Option {
+	return func(client *TorrentClient) {
+		client.picker = picker
+	}
+}
+
+func WithSyncPolicy(policy SyncPolicy) Option {
+	return func(client *TorrentClient) {
+		client.syncPolicy = policy
+	}
+}
+
+// WithUTPFallback makes the client retry peers over uTP when a TCP connection
+// can't be established.
+func WithUTPFallback(enabled bool) Option {
+	return func(client *TorrentClient) {
+		client.utpFallback = enabled
+	}
+}
+
+// WithDownloadRetries makes Download re-run the whole announce and connect
+// cycle up to n more times after a failure, waiting backoff (doubling on each
+// attempt) in between. Pieces already written and verified are kept.
+func WithDownloadRetries(n int, backoff time.Duration) Option {
+	return func(client *TorrentClient) {
+		client.downloadRetries = n
+		client.retryBackoff = backoff
+	}
+}
+
+// WithEventDelivery sizes the Events channel and chooses whether a slow
+// consumer blocks the download (block == true) or misses events.
+func WithEventDelivery(buffer int, block bool) Option {
+	return func(client *TorrentClient) {
+		client.events.buffer = buffer
+		client.events.block = block
+	}
+}
+
+// WithPort sets the port announced to trackers and listened on by Seed. Zero
+// lets Seed pick an ephemeral port, which Port then reports. A port outside
+// 0-65535 makes the constructor fail with ErrInvalidPort.
+func WithPort(port int) Option {
+	return func(client *TorrentClient) {
+		if port < 0 || port > 65535 {
+			client.setOptionErr(fmt.Errorf("%w: %d", ErrInvalidPort, port))
+			return
+		}
+
+		client.port.Store(int32(port))
+	}
+}
+
+func WithPeerID(peerID [20]byte) Option {
+	return func(client *TorrentClient) {
+		client.PeerID = peerID
+	}
+}
+
+// WithTimeouts sets how long to wait when dialing a peer and for each read
+// from or write to it. A zero value keeps the default. A peer that misses a deadline is
+// dropped and its slot moves on to the next peer.
+func WithTimeouts(dial, read time.Duration) Option {
+	return func(client *TorrentClient) {
+		if dial > 0 {
+			client.dialTimeout = dial
+		}
+
+		if read > 0 {
+			client.readTimeout = read
+		}
+	}
+}
+
+// WithMaxPeers limits how many peers a download connects to at once. Zero
+// means no limit; a negative n makes the constructor fail with
+// ErrInvalidMaxPeers.
+func WithMaxPeers(n int) Option {
+	return func(client *TorrentClient) {
+		if n < 0 {
+			client.setOptionErr(fmt.Errorf("%w: %d", ErrInvalidMaxPeers, n))
+			return
+		}
+
+		client.maxPeers = n
+	}
+}
+
+// WithBlockSize sets the length of the block requests sent to peers. The
+// default of 16KiB is what every peer accepts; a value of zero or less keeps
+// it, and sizes above 128KiB are capped since peers drop larger requests.
+func WithBlockSize(size int) Option {
+	return func(client *TorrentClient) {
+		if size > 0 {
+			client.blockSize = min(size, maxServedBlock)
+		}
+	}
+}
+
+// WithRateLimits caps the aggregate download and upload rates in bytes per
+// second. Zero means unlimited.
+func WithRateLimits(download, upload int64) Option {
+	return func(client *TorrentClient) {
+		client.DownloadRateLimit = download
+		client.UploadRateLimit = upload
+	}
+}
+
+// WithResumeVerify chooses how thoroughly an existing partial download is
+// checked before resuming. See ResumeVerify for the integrity trade-off.
+func WithResumeVerify(mode ResumeVerify) Option {
+	return func(client *TorrentClient) {
+		client.resumeVerify = mode
+	}
+}
+
+// WithExtraTrackers adds announce URLs to query alongside the torrent's own.
+// They are ignored for private torrents.
+func WithExtraTrackers(trackers []string) Option {
+	return func(client *TorrentClient) {
+		client.extraTrackers = append(client.extraTrackers, trackers...)
+	}
+}
+
+// WithPeerDiscovery controls how long a download waits for its first peers,
+// re-querying the peer sources every interval until timeout passes.
+func WithPeerDiscovery(interval, timeout time.Duration) Option {
+	return func(client *TorrentClient) {
+		client.discoveryInterval = interval
+		client.discoveryTimeout = timeout
+	}
+}
+
+// WithDownloadByteCap stops the download once n bytes have been received in
+// total, partial pieces included. Download then returns ErrByteCapReached.
+func WithDownloadByteCap(n int64) Option {
+	return func(client *TorrentClient) {
+		client.downloadByteCap = n
+	}
+}
+
+// WithDHTBootstrap sets the routers a DHT lookup starts from, in the order
+// they are contacted. An empty list disables the DHT.
+func WithDHTBootstrap(nodes []string) Option {
+	return func(client *TorrentClient) {
+		client.dhtBootstrap = nodes
+	}
+}
+
+// WithDHTReadOnly controls whether the DHT node only sends queries (the
+// default) or also answers other nodes and stores the peers they announce.
+func WithDHTReadOnly(readOnly bool) Option {
+	return func(client *TorrentClient) {
+		client.dhtReadOnly = readOnly
+	}
+}
+
+// WithStallTimeout sets how long the download may go without progress once
+// every remaining piece is in flight before it gives up on the current peers
+// with ErrEndgameStalled. Zero disables the watchdog.
+func WithStallTimeout(d time.Duration) Option {
+	return func(client *TorrentClient) {
+		client.stallTimeout = d
+	}
+}
+
+// WithMetrics reports the client's counters and gauges to m.
+func WithMetrics(m Metrics) Option {
+	return func(client *TorrentClient) {
+		client.metrics = m
+	}
+}
+
+// WithDirMode sets the permissions of directories created for the output.
+func WithDirMode(mode os.FileMode) Option {
+	return func(client *TorrentClient) {
+		client.dirMode = mode
+	}
+}
+
+// WithMetadataCache keeps info dicts fetched by FetchMetadata in dir, named
+// after the info hash.
+func WithMetadataCache(dir string) Option {
+	return func(client *TorrentClient) {
+		client.metadataCacheDir = dir
+	}
+}
+
+// WithProgress calls fn after every piece that is verified and written. It
+// runs on the download loop, so it should return quickly.
+func WithProgress(fn func(ProgressEvent)) Option {
+	return func(client *TorrentClient) {
+		client.onProgress = fn
+	}
+}
+
+// WithPipelineDepth sets how many block requests are kept outstanding to each
+// peer. Higher values hide more round-trip latency; values below 1 are raised
+// to 1.
+func WithPipelineDepth(n int) Option {
+	return func(client *TorrentClient) {
+		client.pipelineDepth = max(n, 1)
+	}
+}
+
+// WithPieceRetries sets how many times a single piece may fail verification,
+// across all peers, before the download gives up, and how long a failed piece
+// waits (doubling after each failure) before another peer may try it. Pieces
+// lost to a failed connection are requeued without counting.
+func WithPieceRetries(attempts int, backoff time.Duration) Option {
+	return func(client *TorrentClient) {
+		client.pieceAttempts = max(attempts, 1)
+		client.pieceBackoff = backoff
+	}
+}
+
+// WithLogger sends the client's diagnostic messages to l instead of
+// discarding them.
+func WithLogger(l Logger) Option {
+	return func(client *TorrentClient) {
+		client.logger = l
+	}
+}
+
+// WithStorage writes downloads through the Storage that open returns instead
+// of to files on disk. truncate is false when an earlier partial download is
+// being resumed. Resuming and MissingPieces still read the output path, so a
+// custom backend starts from scratch each time unless it mirrors that path.
+func WithStorage(open func(output string, truncate bool) (Storage, error)) Option {
+	return func(client *TorrentClient) {
+		client.storage = open
+	}
+}
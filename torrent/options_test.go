@@ -0,0 +1,66 @@
+package torrent
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWithBlockSize(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+		want int
+	}{
+		{"default", 0, defaultBlockSize},
+		{"negative", -1, defaultBlockSize},
+		{"smaller", 4 * 1024, 4 * 1024},
+		{"largest served", maxServedBlock, maxServedBlock},
+		{"oversized", maxServedBlock + 1, maxServedBlock},
+	}
+
+	for _, tt := range tests {
+		client := &TorrentClient{blockSize: defaultBlockSize}
+		WithBlockSize(tt.size)(client)
+
+		if client.blockSize != tt.want {
+			t.Errorf("%s: blockSize = %d, want %d", tt.name, client.blockSize, tt.want)
+		}
+	}
+}
+
+func TestWithMaxPeers(t *testing.T) {
+	path := writeTestTorrent(t, testTorrent(testData(100), 64), "http://tracker.invalid/announce")
+
+	tests := []struct {
+		name    string
+		n       int
+		want    int
+		wantErr bool
+	}{
+		{"limited", 5, 5, false},
+		{"unlimited", 0, 0, false},
+		{"negative", -1, 0, true},
+	}
+
+	for _, tt := range tests {
+		client, err := NewTorrentClient(path, WithMaxPeers(tt.n))
+
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidMaxPeers) {
+				t.Errorf("%s: NewTorrentClient() error = %v, want ErrInvalidMaxPeers", tt.name, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if client.maxPeers != tt.want {
+			t.Errorf("%s: maxPeers = %d, want %d", tt.name, client.maxPeers, tt.want)
+		}
+
+		client.Close()
+	}
+}
@@ -0,0 +1,89 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrUnsafePath = errors.New("unsafe path in torrent")
+
+// filePath joins a torrent-supplied path under root. Path elements come from
+// untrusted metadata, so empty, "." and ".." elements, separators inside an
+// element and absolute paths are all rejected, and the cleaned result must
+// still lie inside root.
+func filePath(root string, parts []string) (string, error) {
+	if len(parts) == 0 {
+		return "", fmt.Errorf("%w: empty path", ErrUnsafePath)
+	}
+
+	for _, part := range parts {
+		switch {
+		case part == "", part == ".", part == "..":
+			return "", fmt.Errorf("%w: element %q", ErrUnsafePath, part)
+		case strings.ContainsAny(part, `/\`), strings.ContainsRune(part, 0):
+			return "", fmt.Errorf("%w: element %q contains a separator", ErrUnsafePath, part)
+		case filepath.IsAbs(part), filepath.VolumeName(part) != "":
+			return "", fmt.Errorf("%w: absolute element %q", ErrUnsafePath, part)
+		}
+	}
+
+	root = filepath.Clean(root)
+	path := filepath.Join(append([]string{root}, parts...)...)
+
+	rel, err := filepath.Rel(root, path)
+
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %s escapes %s", ErrUnsafePath, filepath.Join(parts...), root)
+	}
+
+	return path, nil
+}
+
+// validatePaths checks the directory name and every file path of a multi-file
+// torrent, so a malicious torrent is rejected when it is loaded rather than
+// halfway through a download.
+func (m MetaInfo) validatePaths() error {
+	if len(m.Files) == 0 {
+		return nil
+	}
+
+	if _, err := filePath(".", []string{m.DisplayName()}); err != nil {
+		return fmt.Errorf("invalid name: %w", err)
+	}
+
+	for _, file := range m.Files {
+		if _, err := filePath(m.DisplayName(), file.DisplayPath()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DisplayName is the torrent's name, preferring the name.utf-8 field that
+// clients on non-UTF-8 systems add next to the legacy encoded name.
+func (m MetaInfo) DisplayName() string {
+	if m.NameUTF8 != "" && utf8.ValidString(m.NameUTF8) {
+		return m.NameUTF8
+	}
+
+	return m.Name
+}
+
+// DisplayPath is the file's path, preferring path.utf-8 like DisplayName.
+func (f FileInfo) DisplayPath() []string {
+	if len(f.PathUTF8) == 0 {
+		return f.Path
+	}
+
+	for _, part := range f.PathUTF8 {
+		if !utf8.ValidString(part) {
+			return f.Path
+		}
+	}
+
+	return f.PathUTF8
+}
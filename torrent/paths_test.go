@@ -0,0 +1,62 @@
+package torrent
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestFilePath(t *testing.T) {
+	root := filepath.Join("downloads", "torrent")
+
+	tests := []struct {
+		name    string
+		parts   []string
+		want    string
+		wantErr bool
+	}{
+		{"single file", []string{"a.txt"}, filepath.Join(root, "a.txt"), false},
+		{"nested", []string{"dir", "a.txt"}, filepath.Join(root, "dir", "a.txt"), false},
+		{"dotted name", []string{"..hidden"}, filepath.Join(root, "..hidden"), false},
+		{"traversal", []string{"..", "..", "etc", "passwd"}, "", true},
+		{"traversal after a directory", []string{"dir", "..", "..", "passwd"}, "", true},
+		{"separator in element", []string{"../../etc/passwd"}, "", true},
+		{"backslash in element", []string{`..\..\evil`}, "", true},
+		{"absolute", []string{"/etc/passwd"}, "", true},
+		{"dot", []string{".", "a.txt"}, "", true},
+		{"empty element", []string{"", "a.txt"}, "", true},
+		{"nul byte", []string{"a\x00.txt"}, "", true},
+		{"empty path", nil, "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := filePath(root, tt.parts)
+
+		if tt.wantErr {
+			if !errors.Is(err, ErrUnsafePath) {
+				t.Errorf("%s: filePath() = %q, %v, want ErrUnsafePath", tt.name, got, err)
+			}
+
+			continue
+		}
+
+		if err != nil || got != tt.want {
+			t.Errorf("%s: filePath() = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestLoadRejectsTraversingTorrent(t *testing.T) {
+	info := MetaInfo{
+		Name:        "torrent",
+		PieceLength: 16,
+		Pieces:      string(make([]byte, 20)),
+		Files:       []FileInfo{{Length: 10, Path: []string{"..", "..", "etc", "passwd"}}},
+	}
+
+	path := writeTestTorrent(t, info, "http://tracker/announce")
+
+	if _, err := NewTorrentClient(path); !errors.Is(err, ErrUnsafePath) {
+		t.Fatalf("NewTorrentClient() = %v, want ErrUnsafePath", err)
+	}
+}
@@ -0,0 +1,362 @@
+package torrent
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const maxReconnects = 3
+
+type peerConn struct {
+	addr   string
+	conn   net.Conn
+	choked bool
+
+	// bitfield is updated by the peer's worker as have messages arrive and
+	// read from other goroutines, so once the peer is registered it is only
+	// accessed through has, pieces and setPiece.
+	bitfieldMu sync.Mutex
+	bitfield   Bitfield
+
+	// chokedAt is when the peer last choked us during a fetch, or when the
+	// connection was opened while we wait for the first unchoke.
+	chokedAt time.Time
+
+	// fast is set when both sides negotiated the BEP 6 fast extension.
+	fast bool
+
+	// extensions maps the BEP 10 extensions the peer supports to the message
+	// ids it wants us to use, from its extended handshake.
+	extensions map[string]int
+
+	statsMu    sync.Mutex
+	rate       float64
+	downloaded int64
+}
+
+// PeerStat describes one connection of the running download.
+type PeerStat struct {
+	Addr string
+	// Downloaded is the number of piece bytes received from the peer.
+	Downloaded int64
+	// Rate is the peer's smoothed download rate in bytes per second, zero
+	// until it has delivered a batch.
+	Rate float64
+}
+
+func (peer *peerConn) has(index int) bool {
+	peer.bitfieldMu.Lock()
+	defer peer.bitfieldMu.Unlock()
+
+	return peer.bitfield.HasPiece(index)
+}
+
+// pieces returns a copy of the peer's bitfield.
+func (peer *peerConn) pieces() Bitfield {
+	peer.bitfieldMu.Lock()
+	defer peer.bitfieldMu.Unlock()
+
+	return append(Bitfield(nil), peer.bitfield...)
+}
+
+// setPiece marks index as available from the peer, reporting whether it was
+// new.
+func (peer *peerConn) setPiece(index int) bool {
+	peer.bitfieldMu.Lock()
+	defer peer.bitfieldMu.Unlock()
+
+	if peer.bitfield.HasPiece(index) {
+		return false
+	}
+
+	peer.bitfield.SetPiece(index)
+
+	return true
+}
+
+// peerManager indexes the connections of the running download by address.
+type peerManager struct {
+	mu    sync.Mutex
+	peers map[string]*peerConn
+}
+
+func (m *peerManager) add(peer *peerConn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.peers == nil {
+		m.peers = make(map[string]*peerConn)
+	}
+
+	m.peers[peer.addr] = peer
+}
+
+// remove drops peer from the index, reporting whether it was still the
+// connection registered under its address.
+func (m *peerManager) remove(peer *peerConn) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.peers[peer.addr] != peer {
+		return false
+	}
+
+	delete(m.peers, peer.addr)
+
+	return true
+}
+
+func (m *peerManager) get(addr string) (*peerConn, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	peer, ok := m.peers[addr]
+
+	return peer, ok
+}
+
+func (m *peerManager) all() []*peerConn {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	peers := make([]*peerConn, 0, len(m.peers))
+
+	for _, peer := range m.peers {
+		peers = append(peers, peer)
+	}
+
+	return peers
+}
+
+func (m *peerManager) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.peers)
+}
+
+func (client *TorrentClient) registerPeer(peer *peerConn) {
+	client.peerManager.add(peer)
+
+	if tracker, ok := client.picker.(availabilityTracker); ok {
+		tracker.AddPeer(peer.pieces())
+	}
+
+	client.emit(Event{Type: EventPeerConnected, Peer: peer.addr})
+}
+
+func (client *TorrentClient) disconnectPeer(peer *peerConn) {
+	peer.conn.Close()
+
+	if !client.peerManager.remove(peer) {
+		return
+	}
+
+	if tracker, ok := client.picker.(availabilityTracker); ok {
+		tracker.RemovePeer(peer.pieces())
+	}
+
+	client.emit(Event{Type: EventPeerDisconnected, Peer: peer.addr})
+}
+
+// DisconnectPeer closes the connection to the peer at addr. Whatever piece the
+// peer was fetching is handed back to the scheduler for another peer to pick up.
+func (client *TorrentClient) DisconnectPeer(addr string) error {
+	peer, ok := client.peerManager.get(addr)
+
+	if !ok {
+		return fmt.Errorf("not connected to peer %s", addr)
+	}
+
+	client.disconnectPeer(peer)
+
+	return nil
+}
+
+// PeerStats reports the transfer statistics of every connected peer.
+func (client *TorrentClient) PeerStats() []PeerStat {
+	peers := client.peerManager.all()
+
+	stats := make([]PeerStat, 0, len(peers))
+
+	for _, peer := range peers {
+		peer.statsMu.Lock()
+		stats = append(stats, PeerStat{Addr: peer.addr, Downloaded: peer.downloaded, Rate: peer.rate})
+		peer.statsMu.Unlock()
+	}
+
+	return stats
+}
+
+// PeersWithPiece returns the addresses of the connected peers that have
+// piece index.
+func (client *TorrentClient) PeersWithPiece(index int) []string {
+	var addrs []string
+
+	for _, peer := range client.peerManager.all() {
+		if peer.has(index) {
+			addrs = append(addrs, peer.addr)
+		}
+	}
+
+	return addrs
+}
+
+func parseHave(msg *PeerMessage) (int, error) {
+	if len(msg.Payload) != 4 {
+		return 0, fmt.Errorf("%w: have message with %d byte payload", ErrDesync, len(msg.Payload))
+	}
+
+	return int(binary.BigEndian.Uint32(msg.Payload)), nil
+}
+
+// peerHas records a have message from a registered peer, so the scheduler can
+// hand it the piece and the picker counts the extra copy.
+func (client *TorrentClient) peerHas(peer *peerConn, index int) {
+	if index < 0 || index >= client.pieceCount() || !peer.setPiece(index) {
+		return
+	}
+
+	if tracker, ok := client.picker.(availabilityTracker); ok {
+		tracker.AddHave(index)
+	}
+}
+
+// readPeerMessage reads the next message from a peer. The connection from
+// dialPeer enforces the read timeout on every read.
+func (client *TorrentClient) readPeerMessage(conn net.Conn) (*PeerMessage, error) {
+	return readMessage(conn)
+}
+
+func (client *TorrentClient) interested(conn net.Conn) error {
+	_, err := conn.Write([]byte{0, 0, 0, 1, MsgInterested})
+
+	if err != nil {
+		return fmt.Errorf("failed to write to a peer: %v", err)
+	}
+
+	return nil
+}
+
+func (client *TorrentClient) connect(peerAddr string) (*peerConn, error) {
+	if client.bans.banned(peerAddr) {
+		return nil, fmt.Errorf("peer %s is banned", peerAddr)
+	}
+
+	rawConn, reserved, err := client.handshake(peerAddr)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to do a handshake: %v", err)
+	}
+
+	conn := newKeepAliveConn(rawConn)
+
+	peer := &peerConn{
+		addr:     peerAddr,
+		conn:     conn,
+		bitfield: NewBitfield(client.pieceCount()),
+		choked:   true,
+		chokedAt: time.Now(),
+		fast:     supportsFast(reserved),
+	}
+
+	// BEP 6 requires a fast peer to open with have all, have none or a
+	// bitfield. Download connections serve nothing, so they have none.
+	if peer.fast {
+		if err := writeMessage(conn, MsgHaveNone, nil); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	// Private torrents must not learn peers from the swarm, so they don't
+	// offer peer exchange.
+	if supportsExtensions(reserved) && !client.IsPrivate() {
+		if err := sendExtHandshake(conn, map[string]any{"ut_pex": utPexID}); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to send extended handshake: %v", err)
+		}
+	}
+
+	if err := client.interested(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err := client.awaitUnchoke(peer); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to receive unchoke: %w", err)
+	}
+
+	client.registerPeer(peer)
+
+	return peer, nil
+}
+
+// awaitUnchoke reads messages until the peer unchokes us. Peers are free to
+// send bitfield, have, choke and keep-alive messages before that (or not send
+// a bitfield at all), so those are applied to the peer state along the way.
+// Fast extension peers may send have all or have none instead of a bitfield.
+// A peer that keeps us choked past chokeTimeout fails with ErrChokeTimeout, so
+// it can't hold a worker slot by sending keep-alives forever.
+func (client *TorrentClient) awaitUnchoke(peer *peerConn) error {
+	for {
+		if time.Since(peer.chokedAt) > chokeTimeout {
+			return fmt.Errorf("%w after %v", ErrChokeTimeout, chokeTimeout)
+		}
+
+		msg, err := client.readPeerMessage(peer.conn)
+
+		if err != nil {
+			return err
+		}
+
+		if msg == nil {
+			continue
+		}
+
+		switch msg.ID {
+		case MsgUnchoke:
+			peer.choked = false
+			return nil
+		case MsgChoke:
+			peer.choked = true
+		case MsgBitfield:
+			available := Bitfield(msg.Payload)
+
+			if err := available.validate(client.pieceCount()); err != nil {
+				return err
+			}
+
+			peer.bitfield = available
+		case MsgHave:
+			index, err := parseHave(msg)
+			if err != nil {
+				return err
+			}
+
+			peer.bitfield.SetPiece(index)
+		case MsgHaveAll, MsgHaveNone:
+			if !peer.fast {
+				return fmt.Errorf("peer sent fast extension message %d without negotiating it", msg.ID)
+			}
+
+			// A have none peer keeps an empty bitfield, so the scheduler has
+			// nothing to give it until it announces pieces with have.
+			if msg.ID == MsgHaveAll {
+				peer.bitfield = fullBitfield(client.pieceCount())
+			} else {
+				peer.bitfield = NewBitfield(client.pieceCount())
+			}
+		case MsgExtended:
+			client.handleExtended(peer, msg.Payload)
+		case MsgPort:
+			if err := client.addDHTNode(peer, msg); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package torrent
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPeerBitfieldConcurrentAccess(t *testing.T) {
+	client := &TorrentClient{File: TorrentFile{Info: MetaInfo{Length: 64 * 16, PieceLength: 16}}}
+
+	peer := &peerConn{addr: "192.0.2.1:6881", bitfield: NewBitfield(64)}
+	client.peerManager.add(peer)
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+
+	// The peer's worker applies have messages while callers query the swarm.
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < 64; i++ {
+			client.peerHas(peer, i)
+		}
+	}()
+
+	for i := 0; i < 64; i++ {
+		client.PeersWithPiece(i)
+		client.peerSwarmHealth()
+	}
+
+	wg.Wait()
+
+	if got := client.PeersWithPiece(63); !slices.Equal(got, []string{peer.addr}) {
+		t.Fatalf("PeersWithPiece(63) = %v, want [%s]", got, peer.addr)
+	}
+}
+
+func TestPeerHasAfterBitfield(t *testing.T) {
+	picker := &RarestFirstPicker{PieceCount: 4}
+	client := &TorrentClient{File: TorrentFile{Info: MetaInfo{Length: 4 * 16, PieceLength: 16}}, picker: picker}
+
+	peer := &peerConn{addr: "192.0.2.1:6881", bitfield: Bitfield{0xd0}} // pieces 0, 1 and 3
+	picker.AddPeer(peer.pieces())
+
+	if got, ok := picker.Next(peer.pieces(), NewBitfield(4)); !ok || got != 0 {
+		t.Fatalf("Next() before have = %d, %v, want 0", got, ok)
+	}
+
+	client.peerHas(peer, 2)
+
+	if !peer.has(2) {
+		t.Fatal("have for piece 2 was not applied to the peer's bitfield")
+	}
+
+	// Piece 2 now has one copy, like the others, and a repeated have must not
+	// count it twice.
+	client.peerHas(peer, 2)
+	client.peerHas(peer, 4) // out of range
+
+	if got, ok := picker.Next(peer.pieces(), bitfieldOf(4, 0, 1)); !ok || got != 2 {
+		t.Fatalf("Next() after have = %d, %v, want 2", got, ok)
+	}
+}
+
+func TestAwaitUnchokeRejectsMisSizedBitfields(t *testing.T) {
+	client := &TorrentClient{File: TorrentFile{Info: MetaInfo{Length: 10 * 16, PieceLength: 16}}}
+
+	tests := []struct {
+		name     string
+		bitfield Bitfield
+		wantErr  bool
+	}{
+		{"exact", Bitfield{0xff, 0xc0}, false},
+		{"over-length", Bitfield{0xff, 0xc0, 0x00}, true},
+		{"under-length", Bitfield{0xff}, true},
+		{"spare bits set", Bitfield{0xff, 0xff}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, remote := net.Pipe()
+			defer conn.Close()
+
+			go func() {
+				defer remote.Close()
+
+				writeMessage(remote, MsgBitfield, tt.bitfield)
+				writeMessage(remote, MsgUnchoke, nil)
+			}()
+
+			peer := &peerConn{conn: conn, bitfield: NewBitfield(10), choked: true, chokedAt: time.Now()}
+
+			err := client.awaitUnchoke(peer)
+
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				return
+			}
+
+			if !errors.Is(err, ErrInvalidBitfield) {
+				t.Fatalf("awaitUnchoke() = %v, want ErrInvalidBitfield", err)
+			}
+		})
+	}
+}
+
+func TestAwaitUnchokeToleratesInterleavedMessages(t *testing.T) {
+	client := &TorrentClient{File: TorrentFile{Info: MetaInfo{Length: 10 * 16, PieceLength: 16}}}
+
+	conn, remote := net.Pipe()
+	defer conn.Close()
+
+	go func() {
+		defer remote.Close()
+
+		writeMessage(remote, MsgHave, []byte{0, 0, 0, 3})
+		remote.Write([]byte{0, 0, 0, 0}) // keep-alive
+		writeMessage(remote, MsgChoke, nil)
+		writeMessage(remote, MsgBitfield, Bitfield{0x80, 0x00})
+		writeMessage(remote, MsgHave, []byte{0, 0, 0, 9})
+		writeMessage(remote, MsgUnchoke, nil)
+	}()
+
+	peer := &peerConn{conn: conn, bitfield: NewBitfield(10), choked: true, chokedAt: time.Now()}
+
+	if err := client.awaitUnchoke(peer); err != nil {
+		t.Fatal(err)
+	}
+
+	if peer.choked {
+		t.Fatal("peer still choked after unchoke")
+	}
+
+	// The bitfield replaces what the earlier have announced, and the later
+	// have adds to it.
+	if got, want := peer.pieces(), (Bitfield{0x80, 0x40}); !bytes.Equal(got, want) {
+		t.Fatalf("pieces = %08b, want %08b", got, want)
+	}
+}
+
+func TestAwaitUnchokeChokeTimeoutWhileReceivingKeepAlives(t *testing.T) {
+	client := &TorrentClient{File: TorrentFile{Info: MetaInfo{Length: 10 * 16, PieceLength: 16}}}
+
+	conn, remote := net.Pipe()
+	defer conn.Close()
+	defer remote.Close()
+
+	// The peer never unchokes but keeps the connection alive.
+	go func() {
+		for {
+			if _, err := remote.Write([]byte{0, 0, 0, 0}); err != nil {
+				return
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	peer := &peerConn{
+		conn:     conn,
+		bitfield: NewBitfield(10),
+		choked:   true,
+		chokedAt: time.Now().Add(-chokeTimeout + 100*time.Millisecond),
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- client.awaitUnchoke(peer)
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrChokeTimeout) {
+			t.Fatalf("awaitUnchoke() = %v, want ErrChokeTimeout", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("awaitUnchoke kept reading keep-alives past the choke timeout")
+	}
+}
+
+func TestPeersWithPieceDisjointPeers(t *testing.T) {
+	data := testData(4 * 1024)
+
+	first := &testPeer{missing: []int{2, 3}}
+	second := &testPeer{missing: []int{0, 1}}
+
+	client := startSwarm(t, data, 1024, []*testPeer{first, second})
+
+	for _, p := range []*testPeer{first, second} {
+		peer, err := client.connect(p.addr)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer client.disconnectPeer(peer)
+	}
+
+	tests := []struct {
+		index int
+		want  []string
+	}{
+		{0, []string{first.addr}},
+		{1, []string{first.addr}},
+		{2, []string{second.addr}},
+		{3, []string{second.addr}},
+		{4, nil},
+	}
+
+	for _, tt := range tests {
+		if got := client.PeersWithPiece(tt.index); !slices.Equal(got, tt.want) {
+			t.Errorf("PeersWithPiece(%d) = %v, want %v", tt.index, got, tt.want)
+		}
+	}
+}
+
+func TestDisconnectPeerMidDownload(t *testing.T) {
+	const pieceLen = 16 * 1024
+
+	data := testData(8 * pieceLen)
+
+	var client *TorrentClient
+
+	dropped := &testPeer{}
+
+	var once sync.Once
+
+	disconnected := make(chan error, 1)
+
+	// Disconnect the peer while its first request is being served, so it
+	// holds a piece the scheduler has to hand to someone else.
+	dropped.serve = func(index, begin, length int) bool {
+		once.Do(func() { disconnected <- client.DisconnectPeer(dropped.addr) })
+		return true
+	}
+
+	client = startSwarm(t, data, pieceLen, []*testPeer{dropped, {}})
+
+	output := filepath.Join(t.TempDir(), "out")
+
+	if err := client.Download(output); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-disconnected:
+		if err != nil {
+			t.Fatalf("DisconnectPeer() = %v", err)
+		}
+	default:
+		t.Fatal("the peer was never asked for a block")
+	}
+
+	got, err := os.ReadFile(output)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, data) {
+		t.Fatal("downloaded data differs")
+	}
+}
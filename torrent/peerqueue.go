@@ -0,0 +1,100 @@
+package torrent
+
+import "sync"
+
+// peerQueue hands peer addresses to the download workers. Addresses learned
+// while the download runs, from re-announces or peer exchange, are appended
+// and may start extra workers up to the limit. Each address is queued once.
+type peerQueue struct {
+	mu      sync.Mutex
+	seen    map[string]bool
+	pending []string
+
+	// workers counts running workers; done is closed when it drops to zero,
+	// after which no new workers are started.
+	workers int
+	limit   int
+	start   func()
+	done    chan struct{}
+	closed  bool
+}
+
+func newPeerQueue(limit int, start func()) *peerQueue {
+	return &peerQueue{
+		seen:  make(map[string]bool),
+		limit: limit,
+		start: start,
+		done:  make(chan struct{}),
+	}
+}
+
+// add queues the addresses not seen before and starts workers for them while
+// fewer than limit are running. A limit of zero means no limit.
+func (q *peerQueue) add(addrs []string) {
+	q.mu.Lock()
+
+	for _, addr := range addrs {
+		if !q.seen[addr] {
+			q.seen[addr] = true
+			q.pending = append(q.pending, addr)
+		}
+	}
+
+	spawn := 0
+
+	if !q.closed {
+		spawn = len(q.pending)
+
+		if q.limit > 0 {
+			spawn = min(spawn, q.limit-q.workers)
+		}
+
+		q.workers += spawn
+
+		if q.workers == 0 {
+			q.closed = true
+			close(q.done)
+		}
+	}
+
+	q.mu.Unlock()
+
+	for i := 0; i < spawn; i++ {
+		go q.start()
+	}
+}
+
+// next returns the next address for a worker. When the queue is empty the
+// worker is considered gone and must return.
+func (q *peerQueue) next() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.pending) == 0 {
+		q.leaveLocked()
+		return "", false
+	}
+
+	addr := q.pending[0]
+	q.pending = q.pending[1:]
+
+	return addr, true
+}
+
+// leave is called by a worker that returns without asking for another
+// address.
+func (q *peerQueue) leave() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.leaveLocked()
+}
+
+func (q *peerQueue) leaveLocked() {
+	q.workers--
+
+	if q.workers == 0 && !q.closed {
+		q.closed = true
+		close(q.done)
+	}
+}
@@ -0,0 +1,114 @@
+package torrent
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/decoder"
+)
+
+const (
+	// utPexID is the id we ask peers to use for ut_pex messages sent to us.
+	utPexID = 2
+
+	// maxPexPeers caps the addresses taken from each list of a single ut_pex
+	// message, the limit BEP 11 sets for senders.
+	maxPexPeers = 50
+)
+
+// handleExtended applies a BEP 10 message from a connected peer: its
+// extended handshake records the extension ids it negotiated, and ut_pex
+// messages add peers. Anything else is ignored.
+func (client *TorrentClient) handleExtended(peer *peerConn, payload []byte) {
+	if len(payload) == 0 {
+		return
+	}
+
+	if payload[0] == extHandshakeID {
+		hs, err := parseExtHandshake(payload[1:])
+
+		if err != nil {
+			client.logger.Debugf("ignoring extended handshake from %s: %v", peer.addr, err)
+			return
+		}
+
+		// Later handshakes update the map, and an id of 0 turns an
+		// extension off.
+		if peer.extensions == nil {
+			peer.extensions = make(map[string]int)
+		}
+
+		for name, id := range hs.M {
+			if id == 0 {
+				delete(peer.extensions, name)
+			} else {
+				peer.extensions[name] = id
+			}
+		}
+
+		return
+	}
+
+	if payload[0] != utPexID || client.IsPrivate() {
+		return
+	}
+
+	peers, err := parsePex(payload[1:])
+
+	if err != nil {
+		client.logger.Debugf("ignoring peer exchange message: %v", err)
+		return
+	}
+
+	client.addPeers(peers)
+}
+
+// parsePex returns the addresses in the added and added6 lists of a ut_pex
+// message. Dropped peers are ignored: a connection we already have fails on
+// its own, and the rest simply stay in the queue.
+func parsePex(payload []byte) ([]string, error) {
+	decoded, err := decoder.New(payload).Decode()
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode peer exchange message: %v", err)
+	}
+
+	dict, ok := decoded.(map[string]any)
+
+	if !ok {
+		return nil, fmt.Errorf("peer exchange message is not a dict")
+	}
+
+	added, _ := dict["added"].(string)
+	added6, _ := dict["added6"].(string)
+
+	peers := parsePeers([]byte(added))
+	peers6 := parsePeers6([]byte(added6))
+
+	return append(peers[:min(len(peers), maxPexPeers)], peers6[:min(len(peers6), maxPexPeers)]...), nil
+}
+
+// addPeers merges addrs into Peers and, while a download runs, queues the new
+// ones for connecting. Addresses already queued are not dialed again.
+// knownPeers returns a copy of Peers, which announces and peer exchange
+// update while a download runs.
+func (client *TorrentClient) knownPeers() []string {
+	client.peersMu.Lock()
+	defer client.peersMu.Unlock()
+
+	return slices.Clone(client.Peers)
+}
+
+func (client *TorrentClient) addPeers(addrs []string) {
+	if len(addrs) == 0 {
+		return
+	}
+
+	client.peersMu.Lock()
+	client.Peers = mergeUnique(client.Peers, addrs)
+	client.peersMu.Unlock()
+
+	if queue := client.activePeers.Load(); queue != nil {
+		queue.add(addrs)
+	}
+}
@@ -0,0 +1,139 @@
+package torrent
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// PiecePicker decides which piece to request next from a peer that advertises
+// available and given the pieces we already have (or have in flight).
+type PiecePicker interface {
+	Next(available Bitfield, have Bitfield) (index int, ok bool)
+}
+
+// availabilityTracker is implemented by pickers that want to know how many
+// connected peers have each piece.
+type availabilityTracker interface {
+	AddPeer(bf Bitfield)
+	RemovePeer(bf Bitfield)
+	AddHave(index int)
+}
+
+// defaultPicker fetches the rarest pieces first unless WithPiecePicker chose
+// otherwise, which keeps scarce pieces spreading through the swarm. Streaming
+// callers that want pieces in order can pass a SequentialPicker.
+func (client *TorrentClient) defaultPicker() {
+	if client.picker == nil {
+		client.picker = &RarestFirstPicker{PieceCount: client.pieceCount()}
+	}
+}
+
+type SequentialPicker struct {
+	PieceCount int
+}
+
+func (p *SequentialPicker) Next(available Bitfield, have Bitfield) (int, bool) {
+	for i := 0; i < p.PieceCount; i++ {
+		if available.HasPiece(i) && !have.HasPiece(i) {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+type RarestFirstPicker struct {
+	PieceCount int
+
+	mu     sync.Mutex
+	counts []int
+}
+
+func (p *RarestFirstPicker) ensureCounts() {
+	if p.counts == nil {
+		p.counts = make([]int, p.PieceCount)
+	}
+}
+
+func (p *RarestFirstPicker) AddPeer(bf Bitfield) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.ensureCounts()
+
+	for i := range p.counts {
+		if bf.HasPiece(i) {
+			p.counts[i]++
+		}
+	}
+}
+
+func (p *RarestFirstPicker) RemovePeer(bf Bitfield) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.ensureCounts()
+
+	for i := range p.counts {
+		if bf.HasPiece(i) && p.counts[i] > 0 {
+			p.counts[i]--
+		}
+	}
+}
+
+func (p *RarestFirstPicker) AddHave(index int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.ensureCounts()
+
+	if index >= 0 && index < len(p.counts) {
+		p.counts[index]++
+	}
+}
+
+func (p *RarestFirstPicker) Next(available Bitfield, have Bitfield) (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.ensureCounts()
+
+	best, bestCount := -1, 0
+
+	for i := 0; i < p.PieceCount; i++ {
+		if !available.HasPiece(i) || have.HasPiece(i) {
+			continue
+		}
+
+		if best == -1 || p.counts[i] < bestCount {
+			best, bestCount = i, p.counts[i]
+		}
+	}
+
+	return best, best != -1
+}
+
+type RandomPicker struct {
+	PieceCount int
+	Rand       *rand.Rand
+}
+
+func (p *RandomPicker) Next(available Bitfield, have Bitfield) (int, bool) {
+	var candidates []int
+
+	for i := 0; i < p.PieceCount; i++ {
+		if available.HasPiece(i) && !have.HasPiece(i) {
+			candidates = append(candidates, i)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return 0, false
+	}
+
+	if p.Rand != nil {
+		return candidates[p.Rand.Intn(len(candidates))], true
+	}
+
+	return candidates[rand.Intn(len(candidates))], true
+}
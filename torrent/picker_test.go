@@ -0,0 +1,138 @@
+package torrent
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func bitfieldOf(pieceCount int, pieces ...int) Bitfield {
+	bf := NewBitfield(pieceCount)
+
+	for _, i := range pieces {
+		bf.SetPiece(i)
+	}
+
+	return bf
+}
+
+func TestSequentialPicker(t *testing.T) {
+	tests := []struct {
+		name      string
+		available Bitfield
+		have      Bitfield
+		want      int
+		wantOK    bool
+	}{
+		{"first piece", fullBitfield(4), NewBitfield(4), 0, true},
+		{"skips pieces we have", fullBitfield(4), bitfieldOf(4, 0, 1), 2, true},
+		{"skips pieces the peer lacks", bitfieldOf(4, 3), NewBitfield(4), 3, true},
+		{"nothing to fetch", bitfieldOf(4, 1), bitfieldOf(4, 1), 0, false},
+	}
+
+	for _, tt := range tests {
+		picker := &SequentialPicker{PieceCount: 4}
+
+		got, ok := picker.Next(tt.available, tt.have)
+
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("%s: Next() = %d, %v, want %d, %v", tt.name, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestRarestFirstPicker(t *testing.T) {
+	tests := []struct {
+		name      string
+		peers     []Bitfield
+		haves     []int
+		removed   []Bitfield
+		available Bitfield
+		have      Bitfield
+		want      int
+		wantOK    bool
+	}{
+		{
+			name:      "rarest piece",
+			peers:     []Bitfield{fullBitfield(4), bitfieldOf(4, 0, 1, 3), bitfieldOf(4, 0, 3)},
+			available: fullBitfield(4),
+			have:      NewBitfield(4),
+			want:      2,
+			wantOK:    true,
+		},
+		{
+			name:      "have messages count",
+			peers:     []Bitfield{fullBitfield(4)},
+			haves:     []int{0, 0, 1},
+			available: bitfieldOf(4, 0, 1, 2),
+			have:      NewBitfield(4),
+			want:      2,
+			wantOK:    true,
+		},
+		{
+			name:      "departed peers no longer count",
+			peers:     []Bitfield{fullBitfield(4), fullBitfield(4), bitfieldOf(4, 1, 2, 3)},
+			removed:   []Bitfield{bitfieldOf(4, 1, 2, 3)},
+			available: bitfieldOf(4, 0, 3),
+			have:      NewBitfield(4),
+			want:      0,
+			wantOK:    true,
+		},
+		{
+			name:      "ties go to the lowest index",
+			peers:     []Bitfield{fullBitfield(4)},
+			available: fullBitfield(4),
+			have:      bitfieldOf(4, 0),
+			want:      1,
+			wantOK:    true,
+		},
+		{
+			name:      "nothing to fetch",
+			peers:     []Bitfield{fullBitfield(4)},
+			available: bitfieldOf(4, 2),
+			have:      bitfieldOf(4, 2),
+			want:      -1,
+			wantOK:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		picker := &RarestFirstPicker{PieceCount: 4}
+
+		for _, bf := range tt.peers {
+			picker.AddPeer(bf)
+		}
+
+		for _, index := range tt.haves {
+			picker.AddHave(index)
+		}
+
+		for _, bf := range tt.removed {
+			picker.RemovePeer(bf)
+		}
+
+		got, ok := picker.Next(tt.available, tt.have)
+
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("%s: Next() = %d, %v, want %d, %v", tt.name, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestRandomPickerOnlyPicksWantedPieces(t *testing.T) {
+	picker := &RandomPicker{PieceCount: 8, Rand: rand.New(rand.NewSource(1))}
+
+	available := bitfieldOf(8, 1, 3, 5, 7)
+	have := bitfieldOf(8, 3)
+
+	for i := 0; i < 100; i++ {
+		got, ok := picker.Next(available, have)
+
+		if !ok || !available.HasPiece(got) || have.HasPiece(got) {
+			t.Fatalf("Next() = %d, %v, want one of 1, 5 or 7", got, ok)
+		}
+	}
+
+	if _, ok := picker.Next(available, available); ok {
+		t.Fatal("Next() found a piece with nothing left to fetch")
+	}
+}
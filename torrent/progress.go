@@ -0,0 +1,22 @@
+package torrent
+
+type ProgressEvent struct {
+	PiecesCompleted int
+	TotalPieces     int
+	BytesDownloaded int64
+}
+
+// Progress returns how far the current download has got.
+func (client *TorrentClient) Progress() ProgressEvent {
+	return ProgressEvent{
+		PiecesCompleted: int(client.piecesDone.Load()),
+		TotalPieces:     client.pieceCount(),
+		BytesDownloaded: client.downloaded.Load(),
+	}
+}
+
+func (client *TorrentClient) reportProgress() {
+	if client.onProgress != nil {
+		client.onProgress(client.Progress())
+	}
+}
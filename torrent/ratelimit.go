@@ -0,0 +1,53 @@
+package torrent
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket shared by every peer goroutine, so the rate
+// it enforces is the aggregate across connections. The rate is passed to each
+// wait, so changing the client's limit takes effect on the next block; a rate
+// of zero or less is unlimited.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+func (l *rateLimiter) wait(rate int64, n int) {
+	if rate <= 0 || n <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+
+	now := time.Now()
+
+	// A new rate starts from a full bucket, as a fresh limiter would.
+	if rate != l.rate {
+		l.rate = rate
+		l.tokens = float64(rate)
+		l.last = now
+	}
+
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	l.last = now
+
+	if l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+
+	l.tokens -= float64(n)
+
+	var delay time.Duration
+
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+	}
+
+	l.mu.Unlock()
+
+	time.Sleep(delay)
+}
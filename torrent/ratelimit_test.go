@@ -0,0 +1,65 @@
+package torrent
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterSharedAcrossGoroutines(t *testing.T) {
+	var limiter rateLimiter
+
+	const rate = 64 * 1024
+
+	start := time.Now()
+
+	var wg sync.WaitGroup
+
+	// A full bucket plus as much again spread over four goroutines takes
+	// about a second at the aggregate rate.
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 8; j++ {
+				limiter.wait(rate, rate/16)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if elapsed := time.Since(start); elapsed < 800*time.Millisecond {
+		t.Fatalf("sent %d bytes at %d bytes/s in %v", 2*rate, rate, elapsed)
+	}
+
+	start = time.Now()
+	limiter.wait(0, 10*rate)
+
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("unlimited wait took %v", elapsed)
+	}
+}
+
+func TestDownloadRateLimitField(t *testing.T) {
+	const pieceLen = 16 * 1024
+
+	data := testData(4 * pieceLen)
+	client := startSwarm(t, data, pieceLen, []*testPeer{{}, {}})
+
+	// Half the data fits the initial bucket, the rest waits a second.
+	client.DownloadRateLimit = 2 * pieceLen
+
+	start := time.Now()
+
+	if err := client.Download(filepath.Join(t.TempDir(), "out")); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 800*time.Millisecond {
+		t.Fatalf("downloaded %d bytes at %d bytes/s in %v", len(data), client.DownloadRateLimit, elapsed)
+	}
+}
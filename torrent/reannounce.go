@@ -0,0 +1,101 @@
+package torrent
+
+import (
+	"context"
+	"time"
+)
+
+// defaultAnnounceInterval is used until a tracker tells us its interval.
+const defaultAnnounceInterval = 30 * time.Minute
+
+// recordInterval keeps the shortest re-announce interval any tracker asked
+// for, in seconds.
+func (client *TorrentClient) recordInterval(seconds int) {
+	if seconds <= 0 {
+		return
+	}
+
+	for {
+		current := client.announceInterval.Load()
+
+		if current != 0 && current <= int64(seconds) {
+			return
+		}
+
+		if client.announceInterval.CompareAndSwap(current, int64(seconds)) {
+			return
+		}
+	}
+}
+
+// recordMinInterval keeps the largest BEP 23 min interval any tracker asked
+// for, in seconds, so none of them is announced to too often.
+func (client *TorrentClient) recordMinInterval(seconds int) {
+	for {
+		current := client.minInterval.Load()
+
+		if int64(seconds) <= current {
+			return
+		}
+
+		if client.minInterval.CompareAndSwap(current, int64(seconds)) {
+			return
+		}
+	}
+}
+
+func (client *TorrentClient) minAnnounceInterval() time.Duration {
+	return time.Duration(client.minInterval.Load()) * time.Second
+}
+
+func (client *TorrentClient) reannounceInterval() time.Duration {
+	interval := defaultAnnounceInterval
+
+	if seconds := client.announceInterval.Load(); seconds > 0 {
+		interval = time.Duration(seconds) * time.Second
+	}
+
+	return max(interval, client.minAnnounceInterval())
+}
+
+// announceFloorRemaining is how long until the min interval since the last
+// announce has passed, or zero if another announce is allowed now.
+func (client *TorrentClient) announceFloorRemaining() time.Duration {
+	last := client.lastAnnounce.Load()
+
+	if last == 0 {
+		return 0
+	}
+
+	return max(time.Until(time.Unix(0, last).Add(client.minAnnounceInterval())), 0)
+}
+
+// reannounceLoop re-contacts the trackers every interval while a download
+// runs, so they keep listing us and see up-to-date transfer counters.
+func (client *TorrentClient) reannounceLoop(ctx context.Context, stop <-chan struct{}) {
+	for {
+		select {
+		case <-time.After(client.reannounceInterval()):
+			client.connectTracker(ctx)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// left is how many bytes we still need, for tracker announces.
+func (client *TorrentClient) left() int64 {
+	return max(int64(client.File.Info.totalLength())-client.bytesDone.Load(), 0)
+}
+
+func (client *TorrentClient) completedBytes(done Bitfield) int64 {
+	var total int64
+
+	for i := 0; i < client.pieceCount(); i++ {
+		if done.HasPiece(i) {
+			total += client.pieceSize(i)
+		}
+	}
+
+	return total
+}
@@ -0,0 +1,153 @@
+package torrent
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+)
+
+// ResumeVerify chooses how much of a partial download is re-hashed before
+// resuming. Hashing everything is the only mode that catches on-disk
+// corruption; the cheaper modes rely on the state file recorded alongside the
+// output and trade that guarantee for startup time on large files.
+type ResumeVerify int
+
+const (
+	// ResumeVerifyFull hashes every piece in the output file.
+	ResumeVerifyFull ResumeVerify = iota
+	// ResumeVerifySampled hashes a random subset of the pieces the state
+	// file claims, plus the last one, and falls back to a full scan if any
+	// of them fail.
+	ResumeVerifySampled
+	// ResumeVerifyNone trusts the state file without hashing anything.
+	ResumeVerifyNone
+)
+
+// resumeSampleDivisor controls the sampled scan: one in this many of the
+// recorded pieces is hashed.
+const resumeSampleDivisor = 16
+
+func resumeStatePath(outputFileName string) string {
+	return outputFileName + ".state"
+}
+
+// loadResumeState reads the pieces recorded as written for this torrent. The
+// file is the info hash followed by the bitfield of completed pieces.
+func (client *TorrentClient) loadResumeState(outputFileName string) (Bitfield, bool) {
+	data, err := os.ReadFile(resumeStatePath(outputFileName))
+
+	if err != nil || len(data) < 20 || !bytes.Equal(data[:20], client.InfoHash[:]) {
+		return nil, false
+	}
+
+	have := Bitfield(data[20:])
+
+	if have.validate(client.pieceCount()) != nil {
+		return nil, false
+	}
+
+	return have, true
+}
+
+func (client *TorrentClient) saveResumeState(outputFileName string, have Bitfield) error {
+	data := append(append([]byte(nil), client.InfoHash[:]...), have...)
+
+	tmp := resumeStatePath(outputFileName) + ".tmp"
+
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write resume state: %v", err)
+	}
+
+	return os.Rename(tmp, resumeStatePath(outputFileName))
+}
+
+// resumePieces works out which pieces of an existing output file can be kept,
+// according to the configured ResumeVerify mode.
+func (client *TorrentClient) resumePieces(outputFileName string) (Bitfield, error) {
+	pieceCount := client.pieceCount()
+
+	recorded, ok := client.loadResumeState(outputFileName)
+
+	if !ok || client.resumeVerify == ResumeVerifyFull {
+		return client.scanPieces(outputFileName, nil)
+	}
+
+	if client.resumeVerify == ResumeVerifyNone {
+		return recorded, nil
+	}
+
+	var claimed []int
+
+	for i := 0; i < pieceCount; i++ {
+		if recorded.HasPiece(i) {
+			claimed = append(claimed, i)
+		}
+	}
+
+	if len(claimed) == 0 {
+		return recorded, nil
+	}
+
+	sample := []int{claimed[len(claimed)-1]}
+
+	for _, i := range rand.Perm(len(claimed) - 1)[:(len(claimed)-1)/resumeSampleDivisor] {
+		sample = append(sample, claimed[i])
+	}
+
+	file, err := client.openReader(outputFileName)
+
+	if err != nil {
+		return NewBitfield(pieceCount), nil
+	}
+
+	defer file.Close()
+
+	for _, index := range sample {
+		ok, err := client.verifyFilePiece(file, index)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			return client.scanPieces(outputFileName, recorded)
+		}
+	}
+
+	return recorded, nil
+}
+
+// scanPieces hashes pieces of the output file, limited to those set in only
+// when it is non-nil.
+func (client *TorrentClient) scanPieces(outputFileName string, only Bitfield) (Bitfield, error) {
+	pieceCount := client.pieceCount()
+
+	have := NewBitfield(pieceCount)
+
+	file, err := client.openReader(outputFileName)
+
+	if err != nil {
+		return have, nil
+	}
+
+	defer file.Close()
+
+	for i := 0; i < pieceCount; i++ {
+		if only != nil && !only.HasPiece(i) {
+			continue
+		}
+
+		ok, err := client.verifyFilePiece(file, i)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			have.SetPiece(i)
+		}
+	}
+
+	return have, nil
+}
@@ -0,0 +1,99 @@
+package torrent
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResumeVerifyHashesPieces(t *testing.T) {
+	const pieceLen, pieceCount = 64, 33
+
+	data := testData(pieceCount*pieceLen - 10)
+	path := writeTestTorrent(t, testTorrent(data, pieceLen), "http://127.0.0.1:1/announce")
+
+	tests := []struct {
+		name string
+		mode ResumeVerify
+		want int64
+	}{
+		{"full", ResumeVerifyFull, pieceCount},
+		{"sampled", ResumeVerifySampled, 1 + (pieceCount-1)/resumeSampleDivisor},
+		{"none", ResumeVerifyNone, 0},
+	}
+
+	for _, tt := range tests {
+		metrics := NewMemoryMetrics()
+
+		client, err := NewTorrentClient(path, WithDHTBootstrap(nil), WithResumeVerify(tt.mode), WithMetrics(metrics))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		output := filepath.Join(t.TempDir(), "out")
+
+		if err := os.WriteFile(output, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := client.saveResumeState(output, fullBitfield(pieceCount)); err != nil {
+			t.Fatal(err)
+		}
+
+		have, err := client.resumePieces(output)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(have, fullBitfield(pieceCount)) {
+			t.Errorf("%s: resumePieces() = %08b, want every piece", tt.name, have)
+		}
+
+		if got := metrics.Value(MetricPiecesRehashed); got != tt.want {
+			t.Errorf("%s: hashed %d pieces, want %d", tt.name, got, tt.want)
+		}
+
+		client.Close()
+	}
+}
+
+func TestResumeVerifySampledChecksLastPiece(t *testing.T) {
+	const pieceLen, pieceCount = 64, 33
+
+	data := testData(pieceCount*pieceLen - 10)
+	client := newTestClient(t, data, pieceLen)
+	WithResumeVerify(ResumeVerifySampled)(client)
+
+	output := filepath.Join(t.TempDir(), "out")
+
+	// Only the last piece is damaged, so only a sample that includes it
+	// notices and falls back to hashing what the state claims.
+	damaged := append([]byte(nil), data...)
+	damaged[len(damaged)-1] ^= 0xff
+
+	if err := os.WriteFile(output, damaged, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.saveResumeState(output, fullBitfield(pieceCount)); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		have, err := client.resumePieces(output)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := fullBitfield(pieceCount)
+		want.ClearPiece(pieceCount - 1)
+
+		if !bytes.Equal(have, want) {
+			t.Fatalf("resumePieces() = %08b, want every piece but the last", have)
+		}
+	}
+}
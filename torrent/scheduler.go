@@ -0,0 +1,304 @@
+package torrent
+
+import (
+	"sync"
+	"time"
+)
+
+// endgamePieces is how few pieces must remain, all of them already being
+// fetched, before idle peers are given duplicates of the in-flight pieces so a
+// single slow peer can't hold up the end of the download.
+const endgamePieces = 4
+
+// scheduler hands out pieces to peer workers. A piece is claimed while a
+// worker fetches it and either completed or requeued afterwards, so a peer
+// that dies mid-piece never loses work. In endgame several workers may hold
+// the same piece; the first to finish it wins and the rest drop it.
+type scheduler struct {
+	mu      sync.Mutex
+	changed chan struct{}
+
+	picker    PiecePicker
+	done      Bitfield
+	claimed   Bitfield
+	remaining int
+	inflight  int
+	stopped   bool
+
+	// holders counts the workers fetching each claimed piece.
+	holders  map[int]int
+	failures map[int]int
+}
+
+func newScheduler(picker PiecePicker, done Bitfield, pieceCount int) *scheduler {
+	s := &scheduler{
+		changed: make(chan struct{}),
+		picker:  picker,
+		done:    done,
+		claimed: append(Bitfield(nil), done...),
+		holders: make(map[int]int),
+	}
+
+	for i := 0; i < pieceCount; i++ {
+		if !done.HasPiece(i) {
+			s.remaining++
+		}
+	}
+
+	return s
+}
+
+// broadcast wakes every worker waiting for work. Callers must hold s.mu.
+func (s *scheduler) broadcast() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// next claims a piece the peer advertises in available. It waits while other
+// workers hold pieces that might be requeued, and returns false once there is
+// nothing left this peer could ever be given.
+func (s *scheduler) next(available Bitfield, stop <-chan struct{}) (int, bool) {
+	s.mu.Lock()
+
+	for {
+		if s.stopped || s.remaining == 0 {
+			s.mu.Unlock()
+			return 0, false
+		}
+
+		if index, ok := s.picker.Next(available, s.claimed); ok {
+			s.claim(index)
+			s.mu.Unlock()
+
+			return index, true
+		}
+
+		if index, ok := s.duplicate(available); ok {
+			s.holders[index]++
+			s.mu.Unlock()
+
+			return index, true
+		}
+
+		if s.inflight == 0 {
+			s.mu.Unlock()
+			return 0, false
+		}
+
+		changed := s.changed
+		s.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-stop:
+			return 0, false
+		}
+
+		s.mu.Lock()
+	}
+}
+
+// nextBatch claims up to max pieces for one peer. It waits for the first
+// piece like next and then takes whatever else is immediately available.
+func (s *scheduler) nextBatch(available Bitfield, max int, stop <-chan struct{}) []int {
+	index, ok := s.next(available, stop)
+
+	if !ok {
+		return nil
+	}
+
+	batch := []int{index}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(batch) < max && !s.stopped {
+		index, ok := s.picker.Next(available, s.claimed)
+
+		if !ok {
+			break
+		}
+
+		s.claim(index)
+		batch = append(batch, index)
+	}
+
+	return batch
+}
+
+// claim hands a new piece to a worker. Callers must hold s.mu.
+func (s *scheduler) claim(index int) {
+	s.claimed.SetPiece(index)
+	s.inflight++
+	s.holders[index] = 1
+}
+
+// duplicate picks an in-flight piece in available for an idle worker once
+// the download is in endgame, preferring the piece with the fewest workers.
+// Callers must hold s.mu.
+func (s *scheduler) duplicate(available Bitfield) (int, bool) {
+	if s.remaining > endgamePieces || s.inflight != s.remaining {
+		return 0, false
+	}
+
+	best, found := 0, false
+
+	for index, holders := range s.holders {
+		if holders == 0 || !available.HasPiece(index) {
+			continue
+		}
+
+		if !found || holders < s.holders[best] {
+			best, found = index, true
+		}
+	}
+
+	return best, found
+}
+
+// release drops one worker's hold on piece index. Once no worker holds it,
+// the piece is handed back unless the caller keeps it claimed for a backoff.
+// Callers must hold s.mu.
+func (s *scheduler) release(index int) bool {
+	if s.done.HasPiece(index) || s.holders[index] == 0 {
+		return false
+	}
+
+	s.holders[index]--
+
+	return s.holders[index] == 0
+}
+
+// handedOff records that a worker delivered piece index. It stays claimed
+// until the download loop completes it, but no worker holds it any more, so
+// it is not duplicated in endgame and other holders' requeues are ignored.
+func (s *scheduler) handedOff(index int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.done.HasPiece(index) {
+		s.holders[index] = 0
+	}
+}
+
+// unclaim makes piece index available to workers again. Callers must hold
+// s.mu.
+func (s *scheduler) unclaim(index int) {
+	delete(s.holders, index)
+	s.claimed.ClearPiece(index)
+	s.inflight--
+	s.broadcast()
+}
+
+// requeue gives up a worker's hold on piece index. Pieces already completed
+// by another worker in endgame are left alone.
+func (s *scheduler) requeue(index int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.release(index) {
+		s.unclaim(index)
+	}
+}
+
+// requeueAfter keeps piece index claimed for delay before requeueing it, so
+// workers waiting for work still count it as in flight meanwhile.
+func (s *scheduler) requeueAfter(index int, delay time.Duration) {
+	if delay <= 0 {
+		s.requeue(index)
+		return
+	}
+
+	s.mu.Lock()
+	last := s.release(index)
+	s.mu.Unlock()
+
+	if !last {
+		return
+	}
+
+	time.AfterFunc(delay, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.unclaim(index)
+	})
+}
+
+// failed records a failed attempt at piece index and returns how many times
+// it has failed so far.
+func (s *scheduler) failed(index int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failures == nil {
+		s.failures = make(map[int]int)
+	}
+
+	s.failures[index]++
+
+	return s.failures[index]
+}
+
+func (s *scheduler) complete(index int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.done.HasPiece(index) {
+		return
+	}
+
+	delete(s.holders, index)
+	s.done.SetPiece(index)
+	s.remaining--
+	s.inflight--
+	s.broadcast()
+}
+
+// isDone reports whether piece index has been completed, possibly by another
+// worker.
+func (s *scheduler) isDone(index int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.done.HasPiece(index)
+}
+
+func (s *scheduler) finished() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.remaining == 0
+}
+
+// completed returns a copy of the bitfield of finished pieces.
+func (s *scheduler) completed() Bitfield {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append(Bitfield(nil), s.done...)
+}
+
+func (s *scheduler) left() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.remaining
+}
+
+// endgame reports whether every remaining piece is already claimed by a
+// worker, so no new work can be handed out until one of them finishes.
+func (s *scheduler) endgame() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.remaining > 0 && s.inflight == s.remaining
+}
+
+func (s *scheduler) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stopped = true
+	s.broadcast()
+}
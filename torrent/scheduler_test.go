@@ -0,0 +1,42 @@
+package torrent
+
+import "testing"
+
+func TestSchedulerEndgameDuplicates(t *testing.T) {
+	all := fullBitfield(2)
+
+	sched := newScheduler(&SequentialPicker{PieceCount: 2}, NewBitfield(2), 2)
+
+	first, _ := sched.next(all, nil)
+	second, _ := sched.next(all, nil)
+
+	if first == second {
+		t.Fatalf("both workers got piece %d before endgame", first)
+	}
+
+	// Every remaining piece is in flight, so an idle worker doubles up.
+	dup, ok := sched.next(all, nil)
+
+	if !ok || (dup != first && dup != second) {
+		t.Fatalf("next() = %d, %v, want a duplicate of an in-flight piece", dup, ok)
+	}
+
+	// A delivered piece waits for the download loop to complete it and must
+	// not be handed out again meanwhile.
+	sched.handedOff(first)
+	sched.handedOff(second)
+
+	stop := make(chan struct{})
+	close(stop)
+
+	if index, ok := sched.next(all, stop); ok {
+		t.Fatalf("next() handed out delivered piece %d", index)
+	}
+
+	// The slower holder giving up must not requeue a delivered piece.
+	sched.requeue(dup)
+
+	if !sched.claimed.HasPiece(dup) {
+		t.Fatalf("requeue after delivery made piece %d available again", dup)
+	}
+}
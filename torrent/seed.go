@@ -0,0 +1,210 @@
+package torrent
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// maxServedBlock is the largest block we serve in one piece message. Peers
+// asking for more are dropped, as most clients do.
+const maxServedBlock = 128 * 1024
+
+// Seed serves a download to peers that connect on the configured port. Only
+// pieces of outputFileName that pass verification are advertised and served.
+// It blocks until the listener fails or the client is closed.
+func (client *TorrentClient) Seed(outputFileName string) error {
+	have, err := client.scanPieces(outputFileName, nil)
+
+	if err != nil {
+		return fmt.Errorf("failed to check %s: %v", outputFileName, err)
+	}
+
+	file, err := client.openReader(outputFileName)
+
+	if err != nil {
+		return fmt.Errorf("failed to open file: %v", err)
+	}
+
+	defer file.Close()
+
+	listener, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(client.Port())))
+
+	if err != nil {
+		return fmt.Errorf("failed to listen for peers: %v", err)
+	}
+
+	defer listener.Close()
+
+	// With port 0 the system picked one; announces should carry it.
+	client.port.Store(int32(listener.Addr().(*net.TCPAddr).Port))
+
+	client.logger.Infof("seeding on port %d", client.Port())
+
+	stopListening := context.AfterFunc(client.lifetime, func() {
+		listener.Close()
+	})
+	defer stopListening()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := listener.Accept()
+
+		if client.lifetime.Err() != nil {
+			if conn != nil {
+				conn.Close()
+			}
+
+			return ErrClosed
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to accept a peer: %v", err)
+		}
+
+		wg.Add(1)
+
+		// A misbehaving leecher only costs its own connection.
+		go func() {
+			defer wg.Done()
+			defer conn.Close()
+
+			stop := context.AfterFunc(client.lifetime, func() {
+				conn.Close()
+			})
+			defer stop()
+
+			client.serveSeedPeer(client.withDeadlines(conn), have, file)
+		}()
+	}
+}
+
+// serveSeedPeer answers an incoming handshake, advertises have and then
+// unchokes the peer and serves its requests until it goes away.
+func (client *TorrentClient) serveSeedPeer(conn net.Conn, have Bitfield, file io.ReaderAt) error {
+	buf := make([]byte, 68)
+
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return fmt.Errorf("failed to read handshake: %v", err)
+	}
+
+	if err := client.checkHandshake(buf); err != nil {
+		return err
+	}
+
+	var reserved [8]byte
+	copy(reserved[:], buf[20:28])
+
+	fast := supportsFast(reserved)
+
+	if _, err := conn.Write(client.handshakeMessage()); err != nil {
+		return fmt.Errorf("failed to send handshake: %v", err)
+	}
+
+	if err := client.advertise(conn, have, fast); err != nil {
+		return err
+	}
+
+	unchoked := false
+
+	for {
+		msg, err := readMessage(conn)
+
+		if err != nil {
+			return err
+		}
+
+		if msg == nil {
+			continue
+		}
+
+		switch msg.ID {
+		case MsgInterested:
+			if err := writeMessage(conn, MsgUnchoke, nil); err != nil {
+				return err
+			}
+
+			unchoked = true
+		case MsgRequest:
+			if len(msg.Payload) != 12 {
+				return fmt.Errorf("%w: request message with %d byte payload", ErrDesync, len(msg.Payload))
+			}
+
+			err := client.checkRequest(msg, have)
+
+			// Fast peers are told about every request we drop. Others get
+			// nothing for requests sent while choked, and are disconnected for
+			// ones we can never serve.
+			switch {
+			case fast && (err != nil || !unchoked):
+				if err := writeMessage(conn, MsgRejectRequest, msg.Payload); err != nil {
+					return err
+				}
+
+				continue
+			case !unchoked:
+				continue
+			case err != nil:
+				return err
+			}
+
+			if err := client.servePiece(conn, msg, file); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// advertise sends the pieces we serve. Fast peers must get exactly one of have
+// all, have none or a bitfield, so a complete download goes out as have all.
+func (client *TorrentClient) advertise(conn net.Conn, have Bitfield, fast bool) error {
+	if fast && bytes.Equal(have, fullBitfield(client.pieceCount())) {
+		return writeMessage(conn, MsgHaveAll, nil)
+	}
+
+	return writeMessage(conn, MsgBitfield, have)
+}
+
+// checkRequest reports why a request can't be served, if it can't.
+func (client *TorrentClient) checkRequest(msg *PeerMessage, have Bitfield) error {
+	index := int(binary.BigEndian.Uint32(msg.Payload[0:4]))
+	begin := int64(binary.BigEndian.Uint32(msg.Payload[4:8]))
+	length := int64(binary.BigEndian.Uint32(msg.Payload[8:12]))
+
+	if !have.HasPiece(index) || length == 0 || length > maxServedBlock || begin+length > client.pieceSize(index) {
+		return fmt.Errorf("peer requested %d bytes at %d of piece %d, which we can't serve", length, begin, index)
+	}
+
+	return nil
+}
+
+func (client *TorrentClient) servePiece(conn net.Conn, msg *PeerMessage, file io.ReaderAt) error {
+	index := int(binary.BigEndian.Uint32(msg.Payload[0:4]))
+	begin := int64(binary.BigEndian.Uint32(msg.Payload[4:8]))
+	length := int64(binary.BigEndian.Uint32(msg.Payload[8:12]))
+
+	payload := make([]byte, 8+length)
+	copy(payload, msg.Payload[:8])
+
+	if _, err := file.ReadAt(payload[8:], int64(index)*client.File.Info.PieceLength+begin); err != nil {
+		return fmt.Errorf("failed to read piece %d: %v", index, err)
+	}
+
+	client.uploadLimiter.wait(client.UploadRateLimit, int(length))
+
+	if err := writeMessage(conn, MsgPiece, payload); err != nil {
+		return err
+	}
+
+	client.uploaded.Add(length)
+	client.metrics.AddCounter(MetricBytesUploaded, length)
+
+	return nil
+}
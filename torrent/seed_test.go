@@ -0,0 +1,212 @@
+package torrent
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+func newTestClient(t *testing.T, data []byte, pieceLen int) *TorrentClient {
+	t.Helper()
+
+	path := writeTestTorrent(t, testTorrent(data, pieceLen), "http://127.0.0.1:1/announce")
+
+	client, err := NewTorrentClient(path, WithDHTBootstrap(nil))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+// seedConn runs serveSeedPeer for have on one end of a pipe and returns the
+// other end after exchanging handshakes with the given reserved bytes.
+func seedConn(t *testing.T, client *TorrentClient, data []byte, have Bitfield, reserved [8]byte) net.Conn {
+	t.Helper()
+
+	conn, seeder := net.Pipe()
+
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		defer seeder.Close()
+		client.serveSeedPeer(seeder, have, bytes.NewReader(data))
+	}()
+
+	handshake := append([]byte{19}, protocolString...)
+	handshake = append(handshake, reserved[:]...)
+	handshake = append(handshake, client.InfoHash[:]...)
+	handshake = append(handshake, "-TS0001-testpeer0000"...)
+
+	if _, err := conn.Write(handshake); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := io.ReadFull(conn, make([]byte, 68)); err != nil {
+		t.Fatal(err)
+	}
+
+	return conn
+}
+
+func requestPayload(index, begin, length int) []byte {
+	payload := binary.BigEndian.AppendUint32(nil, uint32(index))
+	payload = binary.BigEndian.AppendUint32(payload, uint32(begin))
+
+	return binary.BigEndian.AppendUint32(payload, uint32(length))
+}
+
+func TestSeedAdvertisesPieces(t *testing.T) {
+	data := testData(3*1024 + 10)
+	client := newTestClient(t, data, 1024)
+
+	partial := NewBitfield(4)
+	partial.SetPiece(1)
+
+	tests := []struct {
+		name     string
+		reserved [8]byte
+		have     Bitfield
+		want     byte
+	}{
+		{"fast peer, complete", ourReserved, fullBitfield(4), MsgHaveAll},
+		{"fast peer, partial", ourReserved, partial, MsgBitfield},
+		{"plain peer, complete", [8]byte{}, fullBitfield(4), MsgBitfield},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := seedConn(t, client, data, tt.have, tt.reserved)
+
+			msg, err := readMessage(conn)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if msg.ID != tt.want {
+				t.Fatalf("first message = %d, want %d", msg.ID, tt.want)
+			}
+		})
+	}
+}
+
+func TestSeedRejectsUnservedRequestsFromFastPeers(t *testing.T) {
+	data := testData(3*1024 + 10)
+	client := newTestClient(t, data, 1024)
+
+	have := fullBitfield(4)
+	have.ClearPiece(2)
+
+	conn := seedConn(t, client, data, have, ourReserved)
+
+	expect := func(id byte, payload []byte) {
+		t.Helper()
+
+		msg, err := readMessage(conn)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if msg.ID != id {
+			t.Fatalf("got message %d, want %d", msg.ID, id)
+		}
+
+		if payload != nil && !bytes.Equal(msg.Payload, payload) {
+			t.Fatalf("message %d payload = %x, want %x", id, msg.Payload, payload)
+		}
+	}
+
+	expect(MsgBitfield, have)
+
+	// Requests sent while choked are rejected.
+	choked := requestPayload(0, 0, 1024)
+	writeMessage(conn, MsgRequest, choked)
+	expect(MsgRejectRequest, choked)
+
+	writeMessage(conn, MsgInterested, nil)
+	expect(MsgUnchoke, nil)
+
+	for _, req := range [][]byte{
+		requestPayload(2, 0, 1024),             // a piece we don't have
+		requestPayload(0, 512, 1024),           // past the end of the piece
+		requestPayload(0, 0, maxServedBlock+1), // too large a block
+	} {
+		writeMessage(conn, MsgRequest, req)
+		expect(MsgRejectRequest, req)
+	}
+
+	// The connection survives the rejections.
+	writeMessage(conn, MsgRequest, requestPayload(3, 0, 10))
+	expect(MsgPiece, append(requestPayload(3, 0, 10)[:8], data[3*1024:]...))
+}
+
+func TestConnectSendsHaveNoneToFastPeers(t *testing.T) {
+	data := testData(3*1024 + 10)
+	client := newTestClient(t, data, 1024)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ln.Close()
+
+	first := make(chan byte, 1)
+
+	go func() {
+		conn, err := ln.Accept()
+
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+
+		if _, err := io.ReadFull(conn, make([]byte, 68)); err != nil {
+			return
+		}
+
+		reply := append([]byte{19}, protocolString...)
+		reply = append(reply, 0, 0, 0, 0, 0, 0, 0, 0x04)
+		reply = append(reply, client.InfoHash[:]...)
+		reply = append(reply, "-TS0001-testpeer0000"...)
+		conn.Write(reply)
+
+		msg, err := readMessage(conn)
+
+		if err != nil {
+			return
+		}
+
+		first <- msg.ID
+
+		writeMessage(conn, MsgHaveAll, nil)
+		writeMessage(conn, MsgUnchoke, nil)
+
+		io.Copy(io.Discard, conn)
+	}()
+
+	peer, err := client.connect(ln.Addr().String())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer client.disconnectPeer(peer)
+
+	if id := <-first; id != MsgHaveNone {
+		t.Fatalf("first message after the handshake = %d, want have none (%d)", id, MsgHaveNone)
+	}
+
+	if got, want := peer.pieces(), fullBitfield(4); !bytes.Equal(got, want) {
+		t.Fatalf("pieces after have all = %08b, want %08b", got, want)
+	}
+}
@@ -0,0 +1,77 @@
+package torrent
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+type progressUpdate struct {
+	Type            string `json:"type"`
+	Peer            string `json:"peer,omitempty"`
+	Piece           int    `json:"piece"`
+	Error           string `json:"error,omitempty"`
+	PiecesDone      int64  `json:"pieces_done"`
+	PieceCount      int    `json:"piece_count"`
+	BytesDownloaded int64  `json:"bytes_downloaded"`
+	TotalLength     int    `json:"total_length"`
+}
+
+// ProgressSSE streams download events to an HTTP client as Server-Sent
+// Events with JSON payloads. Each request gets its own subscription, dropped
+// when the client goes away; a consumer that can't keep up misses events
+// instead of slowing the download.
+func (client *TorrentClient) ProgressSSE(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	events := client.events.subscribe()
+	defer client.events.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-events:
+			data, err := json.Marshal(client.progressUpdate(event))
+
+			if err != nil {
+				continue
+			}
+
+			if _, err := fmt.Fprintf(w, "event: progress\ndata: %s\n\n", data); err != nil {
+				return
+			}
+
+			flusher.Flush()
+		}
+	}
+}
+
+func (client *TorrentClient) progressUpdate(event Event) progressUpdate {
+	update := progressUpdate{
+		Type:            event.Type.String(),
+		Peer:            event.Peer,
+		Piece:           event.Piece,
+		PiecesDone:      client.piecesDone.Load(),
+		PieceCount:      client.pieceCount(),
+		BytesDownloaded: client.downloaded.Load(),
+		TotalLength:     client.File.Info.totalLength(),
+	}
+
+	if event.Err != nil {
+		update.Error = event.Err.Error()
+	}
+
+	return update
+}
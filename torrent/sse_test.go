@@ -0,0 +1,108 @@
+package torrent
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func (s *eventStream) subscribers() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.subs)
+}
+
+func TestProgressSSE(t *testing.T) {
+	client := newTestClient(t, testData(4*1024), 1024)
+
+	server := httptest.NewServer(http.HandlerFunc(client.ProgressSSE))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	waitFor(t, "the stream to subscribe", func() bool { return client.events.subscribers() == 1 })
+
+	client.piecesDone.Store(2)
+	client.emit(Event{Type: EventPeerConnected, Peer: "192.0.2.1:6881"})
+	client.emit(Event{Type: EventPieceCompleted, Peer: "192.0.2.1:6881", Piece: 1})
+
+	scanner := bufio.NewScanner(resp.Body)
+
+	var updates []progressUpdate
+
+	for len(updates) < 2 && scanner.Scan() {
+		line := scanner.Text()
+
+		data, ok := strings.CutPrefix(line, "data: ")
+
+		if !ok {
+			continue
+		}
+
+		var update progressUpdate
+
+		if err := json.Unmarshal([]byte(data), &update); err != nil {
+			t.Fatalf("frame %q is not JSON: %v", line, err)
+		}
+
+		updates = append(updates, update)
+	}
+
+	if len(updates) != 2 {
+		t.Fatalf("read %d frames, want 2 (%v)", len(updates), scanner.Err())
+	}
+
+	if got := updates[0]; got.Type != "peer connected" || got.Peer != "192.0.2.1:6881" {
+		t.Fatalf("first frame = %+v, want the peer connecting", got)
+	}
+
+	if got := updates[1]; got.Type != "piece completed" || got.Piece != 1 || got.PiecesDone != 2 || got.PieceCount != 4 {
+		t.Fatalf("second frame = %+v, want piece 1 completed with 2 of 4 done", got)
+	}
+
+	// Hanging up ends the subscription.
+	resp.Body.Close()
+
+	waitFor(t, "the stream to unsubscribe", func() bool { return client.events.subscribers() == 0 })
+}
+
+func TestProgressSSESlowConsumerDoesNotBlock(t *testing.T) {
+	client := newTestClient(t, testData(4*1024), 1024)
+
+	events := client.events.subscribe()
+	defer client.events.unsubscribe(events)
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		// Far more events than the subscriber buffers, none read.
+		for i := 0; i < 4*defaultEventBuffer; i++ {
+			client.emit(Event{Type: EventPieceCompleted, Piece: i})
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("emit blocked on a subscriber that doesn't read")
+	}
+
+	if len(events) != defaultEventBuffer {
+		t.Fatalf("%d events buffered, want %d", len(events), defaultEventBuffer)
+	}
+}
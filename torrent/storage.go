@@ -0,0 +1,144 @@
+package torrent
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Storage receives verified pieces as they complete. offset is relative to
+// the start of the piece. Sync makes written data durable and Close releases
+// the backend once the download ends.
+type Storage interface {
+	WriteBlock(piece, offset int, data []byte) error
+	Sync() error
+	Close() error
+}
+
+type fileStorage struct {
+	file        *os.File
+	pieceLength int64
+}
+
+// newFileStorage opens the output file and extends it to its full length.
+// Extending with Truncate rather than writing zeros leaves the file sparse,
+// so regions that are never downloaded take no disk space where the
+// filesystem supports holes.
+func newFileStorage(path string, pieceLength, length int64, truncate bool) (*fileStorage, error) {
+	flags := os.O_RDWR | os.O_CREATE
+
+	if truncate {
+		flags |= os.O_TRUNC
+	}
+
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open output file: %v", err)
+	}
+
+	info, err := file.Stat()
+
+	if err == nil && info.Size() < length {
+		err = file.Truncate(length)
+	}
+
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to size output file: %v", err)
+	}
+
+	return &fileStorage{file: file, pieceLength: pieceLength}, nil
+}
+
+func (s *fileStorage) WriteBlock(piece, offset int, data []byte) error {
+	if _, err := s.file.WriteAt(data, int64(piece)*s.pieceLength+int64(offset)); err != nil {
+		return fmt.Errorf("failed to write piece %d: %v", piece, err)
+	}
+
+	return nil
+}
+
+func (s *fileStorage) Sync() error {
+	return s.file.Sync()
+}
+
+func (s *fileStorage) Close() error {
+	return s.file.Close()
+}
+
+type SyncMode int
+
+const (
+	SyncPeriodic SyncMode = iota
+	SyncAlways
+	SyncNever
+)
+
+// SyncPolicy controls how often completed pieces are fsynced to disk. With
+// SyncPeriodic the storage is synced once EveryPieces pieces have been
+// written or Interval has elapsed since the last sync, whichever comes first.
+type SyncPolicy struct {
+	Mode        SyncMode
+	EveryPieces int
+	Interval    time.Duration
+}
+
+var defaultSyncPolicy = SyncPolicy{
+	Mode:        SyncPeriodic,
+	EveryPieces: 16,
+	Interval:    5 * time.Second,
+}
+
+type syncer struct {
+	storage  Storage
+	policy   SyncPolicy
+	pending  int
+	lastSync time.Time
+
+	// onSync runs after every successful sync, once the pieces written so
+	// far are known to be durable.
+	onSync func() error
+}
+
+func newSyncer(storage Storage, policy SyncPolicy) *syncer {
+	return &syncer{storage: storage, policy: policy, lastSync: time.Now()}
+}
+
+func (s *syncer) pieceWritten() error {
+	s.pending++
+
+	switch s.policy.Mode {
+	case SyncAlways:
+		return s.sync()
+	case SyncPeriodic:
+		if (s.policy.EveryPieces > 0 && s.pending >= s.policy.EveryPieces) ||
+			(s.policy.Interval > 0 && time.Since(s.lastSync) >= s.policy.Interval) {
+			return s.sync()
+		}
+	}
+
+	return nil
+}
+
+func (s *syncer) flush() error {
+	if s.policy.Mode == SyncNever || s.pending == 0 {
+		return nil
+	}
+
+	return s.sync()
+}
+
+func (s *syncer) sync() error {
+	if err := s.storage.Sync(); err != nil {
+		return fmt.Errorf("failed to sync output: %v", err)
+	}
+
+	s.pending = 0
+	s.lastSync = time.Now()
+
+	if s.onSync != nil {
+		return s.onSync()
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package torrent
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// spyStorage records the calls made to it instead of writing anywhere.
+type spyStorage struct {
+	mu     sync.Mutex
+	blocks int
+	syncs  int
+	closed bool
+}
+
+func (s *spyStorage) WriteBlock(piece, offset int, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.blocks++
+
+	return nil
+}
+
+func (s *spyStorage) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.syncs++
+
+	return nil
+}
+
+func (s *spyStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+
+	return nil
+}
+
+func TestSyncerPolicies(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    SyncPolicy
+		pieces    int
+		wantSyncs int
+	}{
+		{"always", SyncPolicy{Mode: SyncAlways}, 10, 10},
+		{"periodic by pieces", SyncPolicy{Mode: SyncPeriodic, EveryPieces: 4}, 10, 3},
+		{"periodic, nothing left to flush", SyncPolicy{Mode: SyncPeriodic, EveryPieces: 5}, 10, 2},
+		{"periodic by interval", SyncPolicy{Mode: SyncPeriodic, Interval: time.Nanosecond}, 10, 10},
+		{"never", SyncPolicy{Mode: SyncNever}, 10, 0},
+	}
+
+	for _, tt := range tests {
+		storage := &spyStorage{}
+		s := newSyncer(storage, tt.policy)
+
+		for i := 0; i < tt.pieces; i++ {
+			if err := s.pieceWritten(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := s.flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		if storage.syncs != tt.wantSyncs {
+			t.Errorf("%s: %d syncs for %d pieces, want %d", tt.name, storage.syncs, tt.pieces, tt.wantSyncs)
+		}
+	}
+}
+
+func TestDownloadSyncsStorageByPolicy(t *testing.T) {
+	data := testData(6 * 16 * 1024)
+
+	storage := &spyStorage{}
+
+	open := func(output string, truncate bool) (Storage, error) {
+		return storage, nil
+	}
+
+	client := startSwarm(t, data, 16*1024, []*testPeer{{}}, WithStorage(open), WithSyncPolicy(SyncPolicy{Mode: SyncAlways}))
+
+	if err := client.Download(filepath.Join(t.TempDir(), "out")); err != nil {
+		t.Fatal(err)
+	}
+
+	if storage.syncs != 6 {
+		t.Errorf("%d syncs for 6 pieces with SyncAlways, want 6", storage.syncs)
+	}
+
+	if !storage.closed {
+		t.Error("storage was not closed")
+	}
+}
@@ -0,0 +1,54 @@
+//go:build unix
+
+package torrent
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestFileStorageIsSparse(t *testing.T) {
+	const (
+		pieceLength = 16 * 1024
+		length      = 512 * pieceLength
+	)
+
+	path := filepath.Join(t.TempDir(), "out")
+
+	storage, err := newFileStorage(path, pieceLength, length, true)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the first piece of 512 is downloaded.
+	if err := storage.WriteBlock(0, 0, testData(pieceLength)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Size() != length {
+		t.Fatalf("logical size = %d, want %d", info.Size(), length)
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+
+	if !ok {
+		t.Skip("no allocation info on this platform")
+	}
+
+	if allocated := stat.Blocks * 512; allocated >= length {
+		t.Fatalf("%d bytes allocated on disk for a %d byte file with one piece written", allocated, length)
+	}
+}
@@ -2,39 +2,56 @@ package torrent
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
 	"crypto/sha1"
-	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
-	"math"
 	"net"
 	"net/http"
-	"net/url"
 	"os"
-	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	bencode "github.com/jackpal/bencode-go"
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/decoder"
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/dht"
 )
 
-const Bitfield = 5
-const Interested = 2
-const Unchoke = 1
-const Request = 6
+type FileInfo struct {
+	Length   int      `bencode:"length"`
+	Path     []string `bencode:"path"`
+	PathUTF8 []string `bencode:"path.utf-8,omitempty"`
+}
 
 type MetaInfo struct {
-	Name        string `bencode:"name"`
-	Pieces      string `bencode:"pieces"`
-	Length      int    `bencode:"length"`
-	PieceLength int64  `bencode:"piece length"`
+	Name        string     `bencode:"name"`
+	NameUTF8    string     `bencode:"name.utf-8,omitempty"`
+	Pieces      string     `bencode:"pieces"`
+	Length      int        `bencode:"length,omitempty"`
+	Files       []FileInfo `bencode:"files,omitempty"`
+	PieceLength int64      `bencode:"piece length"`
+	// Private is the BEP 27 private flag, stored in the info dict as the
+	// integer 1.
+	Private bool `bencode:"private,omitempty"`
+
+	// Extra holds the info dict keys MetaInfo has no field for, such as
+	// source or md5sum, so they survive a re-encode.
+	Extra map[string]any `bencode:"-"`
 }
 
 type TorrentFile struct {
-	Announce string   `bencode:"announce"`
-	Info     MetaInfo `bencode:"info"`
-}
-
-type Response struct {
-	Peers string `bencode:"peers"`
+	Announce     string     `bencode:"announce"`
+	AnnounceList [][]string `bencode:"announce-list,omitempty"`
+	Comment      string     `bencode:"comment,omitempty"`
+	CreatedBy    string     `bencode:"created by,omitempty"`
+	CreationDate int64      `bencode:"creation date,omitempty"`
+	Info         MetaInfo   `bencode:"info"`
+
+	// rawInfo holds the info dict exactly as it appeared in the parsed
+	// file, so re-encoding the torrent can't change its info hash.
+	rawInfo []byte
 }
 
 type TorrentClient struct {
@@ -42,240 +59,370 @@ type TorrentClient struct {
 	Peers    []string
 	InfoHash [20]byte
 	PeerID   [20]byte
+
+	// DownloadRateLimit and UploadRateLimit cap the aggregate transfer
+	// rates across all peers, in bytes per second. Zero means unlimited.
+	DownloadRateLimit int64
+	UploadRateLimit   int64
+
+	// peersMu guards Peers once a download runs, since announces and peer
+	// exchange update it from the peer goroutines.
+	peersMu sync.Mutex
+
+	// activePeers is the address queue of the running download, if any, so
+	// peers learned mid-download get connected too.
+	activePeers atomic.Pointer[peerQueue]
+
+	// redirects maps a tracker URL to where it redirected us. Once a tracker
+	// redirects, subsequent announces go straight to the new URL.
+	redirectsMu sync.Mutex
+	redirects   map[string]string
+
+	extraTrackers []string
+
+	// tiers are the BEP 12 tracker tiers, reordered as trackers answer.
+	tiersMu sync.Mutex
+	tiers   [][]string
+
+	// announceCounts holds the seeder/leecher counts from the last announce
+	// that carried them.
+	announceCounts atomic.Pointer[ScrapeResult]
+
+	metadataCacheDir string
+
+	dhtBootstrap []string
+	dhtReadOnly  bool
+
+	// dhtNodes are DHT nodes peers advertised with port messages.
+	dhtNodesMu sync.Mutex
+	dhtNodes   []string
+
+	discoveryInterval time.Duration
+	discoveryTimeout  time.Duration
+
+	// downloaded counts every block received, across retries and
+	// re-announces, so caps and tracker stats see the real total.
+	downloaded      atomic.Int64
+	downloadByteCap int64
+
+	piecesDone atomic.Int64
+	bytesDone  atomic.Int64
+	uploaded   atomic.Int64
+
+	announcedStarted atomic.Bool
+	announceInterval atomic.Int64
+	minInterval      atomic.Int64
+	lastAnnounce     atomic.Int64
+
+	picker      PiecePicker
+	syncPolicy  SyncPolicy
+	storage     func(output string, truncate bool) (Storage, error)
+	dirMode     os.FileMode
+	utpFallback bool
+
+	downloadRetries int
+	retryBackoff    time.Duration
+	pieceAttempts   int
+	pieceBackoff    time.Duration
+	resumeVerify    ResumeVerify
+	stallTimeout    time.Duration
+
+	events     eventStream
+	metrics    Metrics
+	logger     Logger
+	onProgress func(ProgressEvent)
+
+	port            atomic.Int32
+	dialTimeout     time.Duration
+	readTimeout     time.Duration
+	maxPeers        int
+	blockSize       int
+	pipelineDepth   int
+	downloadLimiter rateLimiter
+	uploadLimiter   rateLimiter
+
+	peerManager peerManager
+	bans        banList
+
+	// optionErr is the first invalid value given to an option, returned by
+	// the constructor.
+	optionErr error
+
+	// lifetime is cancelled by Close; downloads and seeding stop with it.
+	lifetime context.Context
+	shutdown context.CancelCauseFunc
 }
 
-func NewTorrentClient(torrentFilePath string) (*TorrentClient, error) {
-	file, err := os.Open(torrentFilePath)
+func NewTorrentClient(torrentFilePath string, opts ...Option) (*TorrentClient, error) {
+	data, err := os.ReadFile(torrentFilePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open torrent file: %v", err)
 	}
-	defer file.Close()
 
-	var torrentFile TorrentFile
-	if err := bencode.Unmarshal(file, &torrentFile); err != nil {
-		return nil, fmt.Errorf("failed to decode torrent file: %v", err)
-	}
-
-	infoHash := sha1.Sum(encodeInfo(torrentFile.Info))
-
-	peerID := generatePeerID()
-
-	return &TorrentClient{
-		File:     torrentFile,
-		InfoHash: infoHash,
-		PeerID:   peerID,
-	}, nil
+	return newTorrentClientFromBytes(data, opts)
 }
 
-func encodeInfo(info MetaInfo) []byte {
-	var buffer bytes.Buffer
-	bencode.Marshal(&buffer, info)
-	return buffer.Bytes()
-}
+// NewTorrentClientFromReader reads a .torrent from r, e.g. standard input.
+func NewTorrentClientFromReader(r io.Reader, opts ...Option) (*TorrentClient, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxTorrentFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read torrent file: %v", err)
+	}
 
-func generatePeerID() [20]byte {
-	var peerID [20]byte
-	copy(peerID[:], "00112233445566778899")
-	return peerID
+	if len(data) > maxTorrentFileSize {
+		return nil, fmt.Errorf("torrent file is larger than %d bytes", maxTorrentFileSize)
+	}
+
+	return newTorrentClientFromBytes(data, opts)
 }
 
-func (client *TorrentClient) ConnectTracker() error {
-	params := url.Values{}
-	params.Add("info_hash", string(client.InfoHash[:]))
-	params.Add("peer_id", string(client.PeerID[:]))
-	params.Add("port", "6881")
-	params.Add("uploaded", "0")
-	params.Add("downloaded", "0")
-	params.Add("left", strconv.Itoa(client.File.Info.Length))
-	params.Add("compact", "1")
-
-	trackerURL := fmt.Sprintf("%s?%s", client.File.Announce, params.Encode())
-	resp, err := http.Get(trackerURL)
+// NewTorrentClientFromURL downloads a .torrent over http or https.
+func NewTorrentClientFromURL(torrentURL string, opts ...Option) (*TorrentClient, error) {
+	resp, err := http.Get(torrentURL)
 	if err != nil {
-		return fmt.Errorf("failed to get peers data: %v", err)
+		return nil, fmt.Errorf("failed to fetch torrent file: %v", err)
 	}
 	defer resp.Body.Close()
 
-	var trackerResponse Response
-	if err := bencode.Unmarshal(resp.Body, &trackerResponse); err != nil {
-		return fmt.Errorf("failed to decode tracker response: %v", err)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch torrent file: %s", resp.Status)
 	}
 
-	client.Peers = parsePeers([]byte(trackerResponse.Peers))
-	return nil
+	return NewTorrentClientFromReader(resp.Body, opts...)
 }
 
-func parsePeers(peersBytes []byte) []string {
-	var peers []string
-	for i := 0; i < len(peersBytes); i += 6 {
-		port := binary.BigEndian.Uint16(peersBytes[i+4 : i+6])
-		peers = append(peers, fmt.Sprintf("%d.%d.%d.%d:%d", peersBytes[i], peersBytes[i+1], peersBytes[i+2], peersBytes[i+3], port))
-	}
-	return peers
-}
+// maxTorrentFileSize bounds how much NewTorrentClientFromReader reads, so a
+// bogus stream can't exhaust memory. Real .torrent files are far smaller.
+const maxTorrentFileSize = 64 << 20
 
-func (client *TorrentClient) Handshake() (net.Conn, error) {
-	peerAddr := client.Peers[0]
+func newTorrentClientFromBytes(data []byte, opts []Option) (*TorrentClient, error) {
+	var torrentFile TorrentFile
+	if err := decoder.Unmarshal(data, &torrentFile); err != nil {
+		return nil, fmt.Errorf("failed to decode torrent file: %v", err)
+	}
 
-	conn, err := net.Dial("tcp", peerAddr)
+	// The info hash is taken over the info dict exactly as it appears in the
+	// file. Re-encoding the struct would drop keys MetaInfo doesn't model
+	// and give a hash no tracker or peer knows.
+	rawInfo, err := decoder.RawValue(data, "info")
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to peer: %v", err)
+		return nil, fmt.Errorf("failed to locate info dict: %v", err)
 	}
 
-	var msg []byte
-	msg = append(msg, byte(19))
-	msg = append(msg, []byte("BitTorrent protocol")...)
-	msg = append(msg, make([]byte, 8)...)
-	msg = append(msg, client.InfoHash[:]...)
-	msg = append(msg, client.PeerID[:]...)
+	torrentFile.rawInfo = rawInfo
 
-	if _, err := conn.Write(msg); err != nil {
-		return nil, fmt.Errorf("failed to send handshake: %v", err)
+	if err := torrentFile.Info.captureExtra(rawInfo); err != nil {
+		return nil, fmt.Errorf("failed to decode torrent file: %v", err)
 	}
 
-	buf := make([]byte, 68)
-	if _, err := conn.Read(buf); err != nil {
-		return nil, fmt.Errorf("failed to read handshake: %v", err)
+	if err := torrentFile.Info.validateLayout(); err != nil {
+		return nil, fmt.Errorf("invalid torrent file: %v", err)
 	}
 
-	peerID := buf[48:]
-	fmt.Printf("Connected to peer: %s, Peer ID: %x\n", peerAddr, peerID)
-	return conn, nil
-}
+	if err := torrentFile.Info.validatePieces(); err != nil {
+		return nil, fmt.Errorf("invalid torrent file: %v", err)
+	}
 
-func (client *TorrentClient) waitForMessage(conn net.Conn, messageId int) error {
-	lengthBuf := make([]byte, 4)
+	if err := torrentFile.Info.validatePaths(); err != nil {
+		return nil, fmt.Errorf("invalid torrent file: %w", err)
+	}
 
-	_, err := conn.Read(lengthBuf)
+	client, err := newTorrentClient(torrentFile, sha1.Sum(rawInfo), opts)
 
 	if err != nil {
-		return fmt.Errorf("failed to read from a peer: %v", err)
+		return nil, err
 	}
 
-	prefixLength := binary.BigEndian.Uint32(lengthBuf)
+	client.defaultPicker()
 
-	message := make([]byte, prefixLength)
+	return client, nil
+}
 
-	_, err = conn.Read(message)
+// newTorrentClient sets up a client with the defaults and applies opts,
+// failing if an option was given an invalid value. The caller picks the
+// default piece picker once the piece count is known.
+func newTorrentClient(torrentFile TorrentFile, infoHash [20]byte, opts []Option) (*TorrentClient, error) {
+	client := &TorrentClient{
+		File:        torrentFile,
+		InfoHash:    infoHash,
+		PeerID:      generatePeerID(),
+		syncPolicy:  defaultSyncPolicy,
+		dirMode:     defaultDirMode,
+		dialTimeout: defaultDialTimeout,
+		readTimeout: defaultReadTimeout,
+		maxPeers:    defaultMaxPeers,
+		blockSize:   defaultBlockSize,
+
+		pipelineDepth: defaultPipelineDepth,
+
+		stallTimeout:  defaultStallTimeout,
+		pieceAttempts: defaultPieceAttempts,
+		pieceBackoff:  defaultPieceBackoff,
+		metrics:       noopMetrics{},
+		logger:        noopLogger{},
+
+		discoveryInterval: defaultDiscoveryInterval,
+		discoveryTimeout:  defaultDiscoveryTimeout,
+
+		dhtBootstrap: dht.DefaultBootstrap,
+		dhtReadOnly:  true,
+	}
 
-	if err != nil {
-		return fmt.Errorf("failed to read from a peer: %v", err)
+	client.port.Store(defaultPort)
+
+	client.lifetime, client.shutdown = context.WithCancelCause(context.Background())
+
+	for _, opt := range opts {
+		opt(client)
 	}
 
-	if message[0] != byte(messageId) {
-		return fmt.Errorf("wanted to recieve %d message id, but got %d", messageId, message[0])
+	if client.optionErr != nil {
+		return nil, client.optionErr
 	}
 
-	return nil
+	return client, nil
 }
 
-func (client *TorrentClient) interested(conn net.Conn) error {
-	_, err := conn.Write([]byte{0, 0, 0, 1, Interested})
+// Port is the port announced to trackers and listened on by Seed. When the
+// client was configured with port 0 it stays 0 until Seed has picked an
+// ephemeral port.
+func (client *TorrentClient) Port() int {
+	return int(client.port.Load())
+}
 
-	if err != nil {
-		return fmt.Errorf("failed to write to a peer: %v", err)
-	}
+// IsPrivate reports whether the torrent is private. Private torrents only get
+// peers from their own trackers: the DHT, extra trackers and peer exchange
+// are skipped.
+func (client *TorrentClient) IsPrivate() bool {
+	return client.File.Info.IsPrivate()
+}
 
-	return nil
+// TotalLength is the size of the torrent's content in bytes, summed over
+// every file of a multi-file torrent.
+func (client *TorrentClient) TotalLength() int {
+	return client.File.Info.totalLength()
 }
 
-func (client *TorrentClient) Download(outputFileName string) error {
-	err := client.ConnectTracker()
+// PieceCount is the number of pieces the content is split into.
+func (client *TorrentClient) PieceCount() int {
+	return client.pieceCount()
+}
 
-	if err != nil {
-		return fmt.Errorf("failed to connect to a tracker: %v", err)
-	}
+// PieceHashes returns the expected SHA-1 digest of every piece, in order.
+func (client *TorrentClient) PieceHashes() [][20]byte {
+	return client.File.Info.PieceHashes()
+}
 
-	conn, err := client.Handshake()
+func (client *TorrentClient) pieceCount() int {
+	return client.File.Info.pieceCount()
+}
 
-	if err != nil {
-		return fmt.Errorf("failed to do a handshake: %v", err)
+// pieceSize is PieceLength for every piece but the last, which holds the
+// remainder, or a full PieceLength when the total is an exact multiple.
+func (client *TorrentClient) pieceSize(index int) int64 {
+	if index == client.pieceCount()-1 {
+		if rest := int64(client.File.Info.totalLength()) % client.File.Info.PieceLength; rest != 0 {
+			return rest
+		}
 	}
 
-	defer conn.Close()
+	return client.File.Info.PieceLength
+}
 
-	client.waitForMessage(conn, Bitfield)
+// peerIDPrefix identifies this client in Azureus style: a dash, a two letter
+// client code, a four digit version and another dash.
+const peerIDPrefix = "-GT0001-"
 
-	client.interested(conn)
+// generatePeerID returns peerIDPrefix followed by random bytes, so every
+// client instance has its own identity. Use WithPeerID for a fixed one.
+func generatePeerID() [20]byte {
+	var peerID [20]byte
+	copy(peerID[:], peerIDPrefix)
+	rand.Read(peerID[len(peerIDPrefix):])
+	return peerID
+}
 
-	client.waitForMessage(conn, Unchoke)
+// Handshake connects to the first known peer that completes a handshake.
+func (client *TorrentClient) Handshake() (net.Conn, error) {
+	lastErr := fmt.Errorf("no peers to connect to")
 
-	pieceSize := client.File.Info.PieceLength
+	for _, peerAddr := range client.knownPeers() {
+		conn, err := client.HandshakePeer(peerAddr)
 
-	pieceCount := int(math.Ceil(float64(client.File.Info.Length) / float64(pieceSize)))
+		if err == nil {
+			return conn, nil
+		}
 
-	blockSize := 16 * 1024
+		lastErr = err
+	}
 
-	var data []byte
+	return nil, lastErr
+}
 
-	for i := 0; i < pieceCount; i++ {
-		if i == pieceCount-1 {
-			pieceSize = int64(client.File.Info.Length) % client.File.Info.PieceLength
-		}
+// HandshakePeer connects to peerAddr and exchanges the protocol handshake.
+func (client *TorrentClient) HandshakePeer(peerAddr string) (net.Conn, error) {
+	conn, _, err := client.handshake(peerAddr)
+	return conn, err
+}
 
-		blockCount := int(math.Ceil(float64(pieceSize) / float64(blockSize)))
+// handshake connects to a peer and exchanges the protocol handshake,
+// returning the reserved bytes the peer advertised.
+func (client *TorrentClient) handshake(peerAddr string) (net.Conn, [8]byte, error) {
+	var reserved [8]byte
 
-		piece, err := client.requestPiece(conn, i, pieceSize, blockSize, blockCount)
+	conn, err := client.dialPeer(peerAddr)
+	if err != nil {
+		return nil, reserved, fmt.Errorf("failed to connect to peer: %v", err)
+	}
 
-		if err != nil {
-			return fmt.Errorf("failed to download a piece: %v", err)
-		}
+	if _, err := conn.Write(client.handshakeMessage()); err != nil {
+		conn.Close()
+		return nil, reserved, fmt.Errorf("failed to send handshake: %v", err)
+	}
 
-		data = append(data, piece...)
+	buf := make([]byte, 68)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		conn.Close()
+		return nil, reserved, fmt.Errorf("failed to read handshake: %v", err)
 	}
 
-	if err := os.WriteFile(outputFileName, data, 0644); err != nil {
-		return fmt.Errorf("failed to write file: %v", err)
+	if err := client.checkHandshake(buf); err != nil {
+		conn.Close()
+		return nil, reserved, err
 	}
 
-	return nil
-}
+	copy(reserved[:], buf[20:28])
 
-func (client *TorrentClient) requestPiece(conn net.Conn, pieceIndex int, pieceSize int64, blockSize int, blockCount int) ([]byte, error) {
-	var data []byte
+	client.logger.Debugf("handshake: peer=%s peer_id=%x", peerAddr, buf[48:])
 
-	for i := 0; i < blockCount; i++ {
-		blockLength := blockSize
-		if i == blockCount-1 {
-			blockLength = int(pieceSize) - (blockCount-1)*blockSize
-		}
+	return conn, reserved, nil
+}
 
-		piece := struct {
-			LengthPrefix uint32
-			ID           uint8
-			Index        uint32
-			Begin        uint32
-			Length       uint32
-		}{
-			LengthPrefix: 13,
-			ID:           Request,
-			Index:        uint32(pieceIndex),
-			Begin:        uint32(i * blockSize),
-			Length:       uint32(blockLength),
-		}
+const protocolString = "BitTorrent protocol"
 
-		var buf bytes.Buffer
-		if err := binary.Write(&buf, binary.BigEndian, piece); err != nil {
-			return nil, fmt.Errorf("failed to serialize piece request: %v", err)
-		}
+// ErrBadHandshake is returned when a peer answers with a different protocol
+// or for a different torrent than the one we asked for.
+var ErrBadHandshake = errors.New("invalid handshake")
 
-		if _, err := conn.Write(buf.Bytes()); err != nil {
-			return nil, fmt.Errorf("failed to send piece request: %v", err)
-		}
+func (client *TorrentClient) handshakeMessage() []byte {
+	var msg []byte
+	msg = append(msg, byte(len(protocolString)))
+	msg = append(msg, []byte(protocolString)...)
+	msg = append(msg, ourReserved[:]...)
+	msg = append(msg, client.InfoHash[:]...)
+	msg = append(msg, client.PeerID[:]...)
 
-		lengthBuf := make([]byte, 4)
-		if _, err := conn.Read(lengthBuf); err != nil {
-			return nil, fmt.Errorf("failed to read from peer: %v", err)
-		}
+	return msg
+}
 
-		prefixLength := binary.BigEndian.Uint32(lengthBuf)
-		result := make([]byte, prefixLength)
-		if _, err := io.ReadFull(conn, result); err != nil {
-			return nil, fmt.Errorf("failed to read from peer: %v", err)
-		}
+func (client *TorrentClient) checkHandshake(buf []byte) error {
+	if buf[0] != byte(len(protocolString)) || string(buf[1:20]) != protocolString {
+		return fmt.Errorf("%w: unexpected protocol %q", ErrBadHandshake, buf[1:20])
+	}
 
-		data = append(data, result[9:]...)
+	if !bytes.Equal(buf[28:48], client.InfoHash[:]) {
+		return fmt.Errorf("%w: peer answered for info hash %x", ErrBadHandshake, buf[28:48])
 	}
 
-	return data, nil
+	return nil
 }
@@ -0,0 +1,136 @@
+package torrent
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckHandshake(t *testing.T) {
+	client := newTestClient(t, testData(1024), 1024)
+
+	valid := client.handshakeMessage()
+
+	otherHash := append([]byte(nil), valid...)
+	otherHash[30] ^= 0xff
+
+	otherProtocol := append([]byte(nil), valid...)
+	copy(otherProtocol[1:20], "BitTorrent protocoX")
+
+	badLength := append([]byte(nil), valid...)
+	badLength[0] = 18
+
+	tests := []struct {
+		name    string
+		buf     []byte
+		wantErr bool
+	}{
+		{"valid", valid, false},
+		{"mismatched info hash", otherHash, true},
+		{"other protocol", otherProtocol, true},
+		{"bad protocol length", badLength, true},
+	}
+
+	for _, tt := range tests {
+		err := client.checkHandshake(tt.buf)
+
+		if tt.wantErr != (err != nil) || (err != nil && !errors.Is(err, ErrBadHandshake)) {
+			t.Errorf("%s: checkHandshake() = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestHandshakeRejectsMismatchedInfoHash(t *testing.T) {
+	client := newTestClient(t, testData(1024), 1024)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+
+		handshake := make([]byte, 68)
+
+		if _, err := io.ReadFull(conn, handshake); err != nil {
+			return
+		}
+
+		// Answer for a different torrent.
+		handshake[28] ^= 0xff
+		conn.Write(handshake)
+
+		io.Copy(io.Discard, conn)
+	}()
+
+	if _, _, err := client.handshake(ln.Addr().String()); !errors.Is(err, ErrBadHandshake) {
+		t.Fatalf("handshake() = %v, want ErrBadHandshake", err)
+	}
+}
+
+func TestPieceSize(t *testing.T) {
+	const pieceLen = 1024
+
+	tests := []struct {
+		name   string
+		length int
+		want   []int64
+	}{
+		{"exact multiple", 2 * pieceLen, []int64{pieceLen, pieceLen}},
+		{"short last piece", 2*pieceLen + 10, []int64{pieceLen, pieceLen, 10}},
+		{"single short piece", 10, []int64{10}},
+		{"single full piece", pieceLen, []int64{pieceLen}},
+	}
+
+	for _, tt := range tests {
+		client := newTestClient(t, testData(tt.length), pieceLen)
+
+		if got := client.pieceCount(); got != len(tt.want) {
+			t.Errorf("%s: pieceCount() = %d, want %d", tt.name, got, len(tt.want))
+			continue
+		}
+
+		for i, want := range tt.want {
+			if got := client.pieceSize(i); got != want {
+				t.Errorf("%s: pieceSize(%d) = %d, want %d", tt.name, i, got, want)
+			}
+		}
+	}
+}
+
+func TestDownloadTwoFullPieces(t *testing.T) {
+	const pieceLen = 32 * 1024
+
+	data := testData(2 * pieceLen)
+	client := startSwarm(t, data, pieceLen, []*testPeer{{}})
+
+	output := filepath.Join(t.TempDir(), "out")
+
+	if err := client.Download(output); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(output)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, data) {
+		t.Fatalf("downloaded %d bytes, want %d", len(got), len(data))
+	}
+}
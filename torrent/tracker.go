@@ -0,0 +1,579 @@
+package torrent
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/decoder"
+)
+
+// httpTrackerTimeout bounds a whole HTTP announce, redirects included, so a
+// tracker that accepts the connection and never answers can't hang discovery.
+const httpTrackerTimeout = 30 * time.Second
+
+type Response struct {
+	Peers    string `bencode:"peers"`
+	Peers6   string `bencode:"peers6"`
+	Interval int    `bencode:"interval"`
+
+	// MinInterval is the BEP 23 floor below which the tracker doesn't want
+	// to be re-announced to.
+	MinInterval int `bencode:"min interval"`
+
+	// Complete and Incomplete are the seeder and leecher counts some
+	// trackers include in the announce response itself.
+	Complete   int `bencode:"complete"`
+	Incomplete int `bencode:"incomplete"`
+
+	// PeerList holds the peers of a non-compact response, which lists them
+	// as dicts instead of packing them into Peers.
+	PeerList []string `bencode:"-"`
+}
+
+func (r *Response) peers() []string {
+	return mergeUnique(parsePeers([]byte(r.Peers)), parsePeers6([]byte(r.Peers6)), r.PeerList)
+}
+
+// httpResponse is an announce reply as it comes off the wire. Peers stays
+// undecoded because trackers that ignore compact=1 send a list of dicts.
+type httpResponse struct {
+	FailureReason string `bencode:"failure reason"`
+	Peers         any    `bencode:"peers"`
+	Peers6        string `bencode:"peers6"`
+	Interval      int    `bencode:"interval"`
+	MinInterval   int    `bencode:"min interval"`
+	Complete      int    `bencode:"complete"`
+	Incomplete    int    `bencode:"incomplete"`
+}
+
+// ErrTrackerFailure is returned when a tracker answers an announce with a
+// failure reason instead of peers.
+var ErrTrackerFailure = errors.New("tracker refused the announce")
+
+func parseHTTPResponse(data []byte) (*Response, error) {
+	var raw httpResponse
+
+	if err := decoder.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	if raw.FailureReason != "" {
+		return nil, fmt.Errorf("%w: %s", ErrTrackerFailure, raw.FailureReason)
+	}
+
+	resp := &Response{
+		Peers6:      raw.Peers6,
+		Interval:    raw.Interval,
+		MinInterval: raw.MinInterval,
+		Complete:    raw.Complete,
+		Incomplete:  raw.Incomplete,
+	}
+
+	switch peers := raw.Peers.(type) {
+	case nil:
+	case string:
+		resp.Peers = peers
+	case []any:
+		for i, entry := range peers {
+			peer, _ := entry.(map[string]any)
+			ip, _ := peer["ip"].(string)
+			port, _ := peer["port"].(int)
+
+			if ip == "" || port <= 0 || port > 65535 {
+				return nil, fmt.Errorf("peer %d is not a valid ip/port dict", i)
+			}
+
+			resp.PeerList = append(resp.PeerList, net.JoinHostPort(ip, strconv.Itoa(port)))
+		}
+	default:
+		return nil, fmt.Errorf("peers has unexpected type %T", raw.Peers)
+	}
+
+	return resp, nil
+}
+
+type ScrapeResult struct {
+	Complete   int `bencode:"complete"`
+	Incomplete int `bencode:"incomplete"`
+	Downloaded int `bencode:"downloaded"`
+}
+
+type scrapeResponse struct {
+	Files map[string]ScrapeResult `bencode:"files"`
+}
+
+// ConnectTracker announces to one tracker of every tier (BEP 12), plus any
+// extra trackers configured with WithExtraTrackers, and merges the peers they
+// return. Within a tier trackers are tried in order until one answers, and
+// that one moves to the front of its tier for next time. It only fails if no
+// tracker could be reached.
+//
+// Calls made before the trackers' min interval has passed since the last
+// announce are skipped and keep the current peer list.
+func (client *TorrentClient) ConnectTracker() error {
+	return client.connectTracker(context.Background())
+}
+
+// connectTracker is ConnectTracker with cancellation: HTTP announces still in
+// flight are abandoned once ctx is done.
+func (client *TorrentClient) connectTracker(ctx context.Context) error {
+	if wait := client.announceFloorRemaining(); wait > 0 {
+		client.logger.Debugf("announce skipped: min interval not reached for another %v", wait)
+		return nil
+	}
+
+	var peers []string
+
+	var lastErr error
+
+	var counts *ScrapeResult
+
+	succeeded := false
+
+	// The first announce of a download tells trackers we've started.
+	event := ""
+
+	if !client.announcedStarted.Load() {
+		event = "started"
+	}
+
+	for tierIndex, tier := range client.trackerTiers() {
+		resp, tracker, err := client.announceTier(ctx, tier, event)
+
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		client.promoteTracker(tierIndex, tracker)
+
+		succeeded = true
+		peers = mergeUnique(peers, resp.peers())
+
+		client.recordInterval(resp.Interval)
+		client.recordMinInterval(resp.MinInterval)
+
+		// Trackers see different parts of the swarm; the largest counts are
+		// the closest to the truth.
+		if resp.Complete > 0 || resp.Incomplete > 0 {
+			if counts == nil {
+				counts = &ScrapeResult{}
+			}
+
+			counts.Complete = max(counts.Complete, resp.Complete)
+			counts.Incomplete = max(counts.Incomplete, resp.Incomplete)
+		}
+	}
+
+	if !succeeded {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("torrent has no trackers")
+		}
+
+		return lastErr
+	}
+
+	client.peersMu.Lock()
+	client.Peers = peers
+	client.peersMu.Unlock()
+
+	if queue := client.activePeers.Load(); queue != nil {
+		queue.add(peers)
+	}
+
+	client.announcedStarted.Store(true)
+	client.lastAnnounce.Store(time.Now().UnixNano())
+
+	client.logger.Infof("announce: next in %v (min interval %v)", client.reannounceInterval(), client.minAnnounceInterval())
+
+	done := Event{Type: EventAnnounceDone, Peers: len(peers)}
+
+	if counts != nil {
+		client.announceCounts.Store(counts)
+		done.Seeders, done.Leechers = counts.Complete, counts.Incomplete
+	}
+
+	client.emit(done)
+	return nil
+}
+
+// announceTier tries the trackers of one tier in order and returns the first
+// answer.
+func (client *TorrentClient) announceTier(ctx context.Context, tier []string, event string) (*Response, string, error) {
+	var lastErr error
+
+	for _, tracker := range tier {
+		resp, err := client.announce(ctx, tracker, event)
+
+		if err != nil {
+			client.metrics.AddCounter(MetricAnnounceErrors, 1)
+			client.logger.Errorf("announce failed: tracker=%s err=%v", tracker, err)
+			lastErr = err
+			continue
+		}
+
+		return resp, tracker, nil
+	}
+
+	return nil, "", lastErr
+}
+
+// trackerTiers returns the tracker tiers, building them on first use: the
+// announce-list tiers, each shuffled as BEP 12 asks, with the plain announce
+// URL as a tier of its own if the list doesn't contain it, followed by one
+// tier per extra tracker unless the torrent is private.
+func (client *TorrentClient) trackerTiers() [][]string {
+	client.tiersMu.Lock()
+	defer client.tiersMu.Unlock()
+
+	if client.tiers == nil {
+		seen := make(map[string]bool)
+
+		addTier := func(trackers []string, shuffle bool) {
+			var tier []string
+
+			for _, tracker := range trackers {
+				if tracker != "" && !seen[tracker] {
+					seen[tracker] = true
+					tier = append(tier, tracker)
+				}
+			}
+
+			if shuffle {
+				rand.Shuffle(len(tier), func(i, j int) { tier[i], tier[j] = tier[j], tier[i] })
+			}
+
+			if len(tier) > 0 {
+				client.tiers = append(client.tiers, tier)
+			}
+		}
+
+		listed := false
+
+		for _, tier := range client.File.AnnounceList {
+			if slices.Contains(tier, client.File.Announce) {
+				listed = true
+			}
+		}
+
+		if !listed {
+			addTier([]string{client.File.Announce}, false)
+		}
+
+		for _, tier := range client.File.AnnounceList {
+			addTier(tier, true)
+		}
+
+		if !client.IsPrivate() {
+			for _, tracker := range client.extraTrackers {
+				addTier([]string{tracker}, false)
+			}
+		}
+	}
+
+	tiers := make([][]string, len(client.tiers))
+
+	for i, tier := range client.tiers {
+		tiers[i] = slices.Clone(tier)
+	}
+
+	return tiers
+}
+
+// promoteTracker moves a tracker that answered to the front of its tier.
+func (client *TorrentClient) promoteTracker(tierIndex int, tracker string) {
+	client.tiersMu.Lock()
+	defer client.tiersMu.Unlock()
+
+	tier := client.tiers[tierIndex]
+
+	if i := slices.Index(tier, tracker); i > 0 {
+		copy(tier[1:i+1], tier[:i])
+		tier[0] = tracker
+	}
+}
+
+// trackers lists every announce URL, tier by tier.
+func (client *TorrentClient) trackers() []string {
+	var trackers []string
+
+	for _, tier := range client.trackerTiers() {
+		trackers = append(trackers, tier...)
+	}
+
+	return trackers
+}
+
+// resolveAnnounce returns the URL to use for tracker, following a redirect it
+// issued on an earlier announce.
+func (client *TorrentClient) resolveAnnounce(tracker string) string {
+	client.redirectsMu.Lock()
+	defer client.redirectsMu.Unlock()
+
+	if redirected, ok := client.redirects[tracker]; ok {
+		return redirected
+	}
+
+	return tracker
+}
+
+// announceEvent sends a one-off event ("completed" or "stopped") to every
+// tracker. Failures are ignored since there is nothing left to do about them.
+func (client *TorrentClient) announceEvent(event string) {
+	for _, tracker := range client.trackers() {
+		client.announce(context.Background(), tracker, event)
+	}
+}
+
+func (client *TorrentClient) announce(ctx context.Context, tracker, event string) (*Response, error) {
+	if strings.HasPrefix(tracker, "udp://") {
+		return client.announceUDP(tracker, event)
+	}
+
+	params := url.Values{}
+	params.Add("info_hash", string(client.InfoHash[:]))
+	params.Add("peer_id", string(client.PeerID[:]))
+	params.Add("port", strconv.Itoa(client.Port()))
+	params.Add("uploaded", strconv.FormatInt(client.uploaded.Load(), 10))
+	params.Add("downloaded", strconv.FormatInt(client.downloaded.Load(), 10))
+	params.Add("left", strconv.FormatInt(client.left(), 10))
+	params.Add("compact", "1")
+
+	if ip := localIPv6(); ip != nil {
+		params.Add("ipv6", ip.String())
+	}
+
+	if event != "" {
+		params.Add("event", event)
+	}
+
+	trackerURL := fmt.Sprintf("%s?%s", client.resolveAnnounce(tracker), params.Encode())
+
+	httpClient := &http.Client{
+		Timeout: httpTrackerTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return fmt.Errorf("stopped after %d redirects", len(via))
+			}
+
+			if req.URL.RawQuery == "" {
+				req.URL.RawQuery = via[0].URL.RawQuery
+			}
+
+			return nil
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, trackerURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build announce request: %v", err)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get peers data: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Request.URL.String() != trackerURL {
+		redirected := *resp.Request.URL
+		redirected.RawQuery = ""
+
+		client.redirectsMu.Lock()
+		if client.redirects == nil {
+			client.redirects = make(map[string]string)
+		}
+		client.redirects[tracker] = redirected.String()
+		client.redirectsMu.Unlock()
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tracker response: %v", err)
+	}
+
+	// Trackers usually explain an error status with a failure reason in
+	// the body, which says more than the status does.
+	trackerResponse, err := parseHTTPResponse(body)
+	if errors.Is(err, ErrTrackerFailure) {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("tracker returned %s", resp.Status)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode tracker response: %v", err)
+	}
+
+	return trackerResponse, nil
+}
+
+// LoadTrackerList reads announce URLs from a file, one per line. Blank lines
+// and lines starting with # are skipped.
+func LoadTrackerList(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tracker list: %v", err)
+	}
+
+	var trackers []string
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		trackers = append(trackers, line)
+	}
+
+	return trackers, nil
+}
+
+// parsePeers decodes the compact IPv4 list: 4 address bytes followed by a
+// 2-byte port per peer. A truncated trailing entry is ignored.
+func parsePeers(peersBytes []byte) []string {
+	var peers []string
+	for i := 0; i+6 <= len(peersBytes); i += 6 {
+		ip := net.IP(peersBytes[i : i+4])
+		port := binary.BigEndian.Uint16(peersBytes[i+4 : i+6])
+		peers = append(peers, net.JoinHostPort(ip.String(), strconv.Itoa(int(port))))
+	}
+	return peers
+}
+
+// parsePeers6 decodes the BEP 7 compact IPv6 list: 16 address bytes followed
+// by a 2-byte port per peer.
+func parsePeers6(peersBytes []byte) []string {
+	var peers []string
+	for i := 0; i+18 <= len(peersBytes); i += 18 {
+		ip := net.IP(peersBytes[i : i+16])
+		port := binary.BigEndian.Uint16(peersBytes[i+16 : i+18])
+		peers = append(peers, net.JoinHostPort(ip.String(), strconv.Itoa(int(port))))
+	}
+	return peers
+}
+
+func mergeUnique(lists ...[]string) []string {
+	seen := make(map[string]bool)
+
+	var merged []string
+
+	for _, list := range lists {
+		for _, peer := range list {
+			if !seen[peer] {
+				seen[peer] = true
+				merged = append(merged, peer)
+			}
+		}
+	}
+
+	return merged
+}
+
+// localIPv6 returns a global unicast IPv6 address of this host, if it has
+// one, for the BEP 7 ipv6 announce parameter.
+func localIPv6() net.IP {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.To4() != nil {
+			continue
+		}
+
+		if ipNet.IP.IsGlobalUnicast() && !ipNet.IP.IsPrivate() {
+			return ipNet.IP
+		}
+	}
+
+	return nil
+}
+
+// ErrScrapeUnsupported is returned by Scrape when the tracker's announce url
+// has no scrape counterpart.
+var ErrScrapeUnsupported = errors.New("tracker does not support scraping")
+
+// scrapeURL derives the scrape url by the usual convention: the last path
+// segment must start with "announce", which is replaced by "scrape".
+func scrapeURL(announce string) (string, error) {
+	u, err := url.Parse(announce)
+	if err != nil {
+		return "", fmt.Errorf("invalid announce url: %v", err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("%w: %s", ErrScrapeUnsupported, announce)
+	}
+
+	i := strings.LastIndex(u.Path, "/")
+	if i == -1 || !strings.HasPrefix(u.Path[i+1:], "announce") {
+		return "", fmt.Errorf("%w: %s", ErrScrapeUnsupported, announce)
+	}
+
+	u.Path = u.Path[:i+1] + "scrape" + strings.TrimPrefix(u.Path[i+1:], "announce")
+
+	return u.String(), nil
+}
+
+// Scrape asks the torrent's tracker for its seeder, leecher and completed
+// download counts without announcing or starting a download.
+func (client *TorrentClient) Scrape() (ScrapeResult, error) {
+	base, err := scrapeURL(client.resolveAnnounce(client.File.Announce))
+	if err != nil {
+		return ScrapeResult{}, err
+	}
+
+	params := url.Values{}
+	params.Add("info_hash", string(client.InfoHash[:]))
+
+	separator := "?"
+	if strings.Contains(base, "?") {
+		separator = "&"
+	}
+
+	resp, err := http.Get(base + separator + params.Encode())
+	if err != nil {
+		return ScrapeResult{}, fmt.Errorf("failed to scrape tracker: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ScrapeResult{}, fmt.Errorf("failed to scrape tracker: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return ScrapeResult{}, fmt.Errorf("failed to read scrape response: %v", err)
+	}
+
+	var scrapeResp scrapeResponse
+	if err := decoder.Unmarshal(body, &scrapeResp); err != nil {
+		return ScrapeResult{}, fmt.Errorf("failed to decode scrape response: %v", err)
+	}
+
+	result, ok := scrapeResp.Files[string(client.InfoHash[:])]
+	if !ok {
+		return ScrapeResult{}, fmt.Errorf("scrape response has no entry for our info hash")
+	}
+
+	return result, nil
+}
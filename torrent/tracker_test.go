@@ -0,0 +1,511 @@
+package torrent
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/decoder"
+)
+
+func TestParsePeersFormatsAddresses(t *testing.T) {
+	tests := []struct {
+		name  string
+		parse func([]byte) []string
+		input []byte
+		want  []string
+	}{
+		{
+			name:  "ipv4",
+			parse: parsePeers,
+			input: []byte{192, 0, 2, 1, 0x1a, 0xe1, 10, 0, 0, 2, 0, 80},
+			want:  []string{"192.0.2.1:6881", "10.0.0.2:80"},
+		},
+		{
+			name:  "ipv4 truncated entry",
+			parse: parsePeers,
+			input: []byte{192, 0, 2, 1, 0x1a, 0xe1, 10, 0},
+			want:  []string{"192.0.2.1:6881"},
+		},
+		{
+			name:  "ipv6",
+			parse: parsePeers6,
+			input: append(net.ParseIP("2001:db8::1"), 0x1a, 0xe1),
+			want:  []string{"[2001:db8::1]:6881"},
+		},
+		{
+			name:  "ipv4-mapped ipv6",
+			parse: parsePeers6,
+			input: append(net.ParseIP("::ffff:192.0.2.1"), 0, 80),
+			want:  []string{"192.0.2.1:80"},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := tt.parse(tt.input); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseHTTPResponseBracketsIPv6PeerDicts(t *testing.T) {
+	resp, err := parseHTTPResponse([]byte("d5:peersld2:ip11:2001:db8::14:porti6881eed2:ip9:192.0.2.14:porti80eeee"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"[2001:db8::1]:6881", "192.0.2.1:80"}; !slices.Equal(resp.PeerList, want) {
+		t.Fatalf("PeerList = %v, want %v", resp.PeerList, want)
+	}
+}
+
+func TestHandshakeDialsIPv6Peers(t *testing.T) {
+	ln, err := net.Listen("tcp", net.JoinHostPort("::1", "0"))
+
+	if err != nil {
+		t.Skipf("no IPv6 loopback: %v", err)
+	}
+
+	defer ln.Close()
+
+	data := testData(1024)
+	client := newTestClient(t, data, 1024)
+
+	go func() {
+		conn, err := ln.Accept()
+
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+
+		handshake := make([]byte, 68)
+
+		if _, err := io.ReadFull(conn, handshake); err != nil {
+			return
+		}
+
+		conn.Write(handshake)
+	}()
+
+	// The address is formatted the way parsePeers6 formats it.
+	port := uint16(ln.Addr().(*net.TCPAddr).Port)
+	addr := parsePeers6(append(net.ParseIP("::1"), byte(port>>8), byte(port)))[0]
+
+	conn, _, err := client.handshake(addr)
+
+	if err != nil {
+		t.Fatalf("handshake(%s): %v", addr, err)
+	}
+
+	conn.Close()
+}
+
+func TestSeedAcceptsIPv6Peers(t *testing.T) {
+	if ln, err := net.Listen("tcp", net.JoinHostPort("::1", "0")); err != nil {
+		t.Skipf("no IPv6 loopback: %v", err)
+	} else {
+		ln.Close()
+	}
+
+	data := testData(1024)
+
+	path := writeTestTorrent(t, testTorrent(data, 1024), "http://127.0.0.1:1/announce")
+	seeded := filepath.Join(t.TempDir(), "test")
+
+	if err := os.WriteFile(seeded, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	seeder, err := NewTorrentClient(path, WithPort(0), WithDHTBootstrap(nil))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer seeder.Close()
+
+	go seeder.Seed(seeded)
+
+	for deadline := time.Now().Add(5 * time.Second); seeder.Port() == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	leecher := newTestClient(t, data, 1024)
+
+	conn, _, err := leecher.handshake(net.JoinHostPort("::1", strconv.Itoa(seeder.Port())))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Close()
+}
+
+func TestAnnounceFollowsRedirects(t *testing.T) {
+	var originalHits, movedHits atomic.Int32
+
+	compact := []byte{192, 0, 2, 1, 0x1a, 0xe1}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/announce", func(w http.ResponseWriter, r *http.Request) {
+		originalHits.Add(1)
+		// Drop the query, as some trackers do when they move.
+		http.Redirect(w, r, "/moved", http.StatusFound)
+	})
+
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		movedHits.Add(1)
+
+		if r.URL.Query().Get("info_hash") == "" {
+			http.Error(w, "missing info_hash", http.StatusBadRequest)
+			return
+		}
+
+		resp, _ := decoder.Encode(map[string]any{"interval": 60, "peers": string(compact)})
+		w.Write(resp)
+	})
+
+	tracker := httptest.NewServer(mux)
+	defer tracker.Close()
+
+	client := newTestClient(t, testData(1024), 1024)
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.announce(context.Background(), tracker.URL+"/announce", "")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := resp.peers(); !slices.Equal(got, []string{"192.0.2.1:6881"}) {
+			t.Fatalf("announce %d: peers = %v, want [192.0.2.1:6881]", i, got)
+		}
+	}
+
+	// The second announce goes straight to the new URL.
+	if originalHits.Load() != 1 || movedHits.Load() != 2 {
+		t.Fatalf("original URL hit %d times and redirect target %d, want 1 and 2", originalHits.Load(), movedHits.Load())
+	}
+
+	if got, want := client.resolveAnnounce(tracker.URL+"/announce"), tracker.URL+"/moved"; got != want {
+		t.Fatalf("resolveAnnounce() = %s, want %s", got, want)
+	}
+}
+
+func TestAnnounceMergesPeersAndPeers6(t *testing.T) {
+	compact := []byte{192, 0, 2, 1, 0x1a, 0xe1, 192, 0, 2, 2, 0x1a, 0xe1}
+	compact6 := append(net.ParseIP("2001:db8::1"), 0x1a, 0xe1)
+	// The same peer reachable over IPv4-mapped IPv6 is listed once.
+	compact6 = append(compact6, append(net.ParseIP("::ffff:192.0.2.1"), 0x1a, 0xe1)...)
+
+	var ipv6 string
+
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ipv6 = r.URL.Query().Get("ipv6")
+
+		resp, _ := decoder.Encode(map[string]any{"interval": 60, "peers": string(compact), "peers6": string(compact6)})
+		w.Write(resp)
+	}))
+	defer tracker.Close()
+
+	client := newTestClient(t, testData(1024), 1024)
+
+	resp, err := client.announce(context.Background(), tracker.URL+"/announce", "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"192.0.2.1:6881", "192.0.2.2:6881", "[2001:db8::1]:6881"}
+
+	if got := resp.peers(); !slices.Equal(got, want) {
+		t.Fatalf("peers = %v, want %v", got, want)
+	}
+
+	if ip := localIPv6(); ip != nil && ipv6 != ip.String() {
+		t.Fatalf("announce sent ipv6=%q, want %s", ipv6, ip)
+	}
+}
+
+func TestExtraTrackersMergeWithAnnounceList(t *testing.T) {
+	list := filepath.Join(t.TempDir(), "trackers.txt")
+
+	contents := "# curated\nhttp://c/announce\n\nhttp://d/announce\nhttp://d/announce\n"
+
+	if err := os.WriteFile(list, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	extra, err := LoadTrackerList(list)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := TorrentFile{
+		Announce:     "http://a/announce",
+		AnnounceList: [][]string{{"http://a/announce", "http://b/announce"}, {"http://c/announce"}},
+	}
+
+	tests := []struct {
+		name    string
+		private bool
+		want    []string
+	}{
+		{"public", false, []string{"http://a/announce", "http://b/announce", "http://c/announce", "http://d/announce"}},
+		{"private", true, []string{"http://a/announce", "http://b/announce", "http://c/announce"}},
+	}
+
+	for _, tt := range tests {
+		file := file
+
+		if tt.private {
+			file.Info.Private = true
+		}
+
+		client := &TorrentClient{File: file}
+		WithExtraTrackers(extra)(client)
+
+		// Tiers are shuffled, so compare the trackers as a set.
+		got := client.trackers()
+		slices.Sort(got)
+
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: trackers = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAnnounceReportsSeedersAndLeechers(t *testing.T) {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/announce", func(w http.ResponseWriter, r *http.Request) {
+		resp, _ := decoder.Encode(map[string]any{
+			"interval":   60,
+			"complete":   7,
+			"incomplete": 3,
+			"peers":      string([]byte{192, 0, 2, 1, 0x1a, 0xe1}),
+		})
+		w.Write(resp)
+	})
+
+	// No /scrape, so SwarmHealth has to fall back to the announce counts.
+	tracker := httptest.NewServer(mux)
+	defer tracker.Close()
+
+	path := writeTestTorrent(t, testTorrent(testData(1024), 1024), tracker.URL+"/announce")
+
+	client, err := NewTorrentClient(path, WithDHTBootstrap(nil), WithEventDelivery(8, true))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := client.Events()
+
+	if err := client.ConnectTracker(); err != nil {
+		t.Fatal(err)
+	}
+
+	if done := <-events; done.Type != EventAnnounceDone || done.Seeders != 7 || done.Leechers != 3 || done.Peers != 1 {
+		t.Fatalf("event = %+v, want announce done with 1 peer, 7 seeders and 3 leechers", done)
+	}
+
+	health, err := client.SwarmHealth()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (SwarmHealth{Seeders: 7, Leechers: 3, Available: true}); health != want {
+		t.Fatalf("SwarmHealth() = %+v, want %+v", health, want)
+	}
+}
+
+func TestParseHTTPResponsePeerFormats(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "compact",
+			body: "d8:intervali60e5:peers12:\xc0\x00\x02\x01\x1a\xe1\xc0\x00\x02\x02\x00\x50e",
+			want: []string{"192.0.2.1:6881", "192.0.2.2:80"},
+		},
+		{
+			name: "dict list",
+			body: "d8:intervali60e5:peersld2:ip9:192.0.2.17:peer id20:aaaaaaaaaaaaaaaaaaaa4:porti6881eed2:ip9:192.0.2.24:porti80eeee",
+			want: []string{"192.0.2.1:6881", "192.0.2.2:80"},
+		},
+		{
+			name: "hostname in dict",
+			body: "d5:peersld2:ip16:peer.example.org4:porti6881eeee",
+			want: []string{"peer.example.org:6881"},
+		},
+		{
+			name: "no peers",
+			body: "d8:intervali60ee",
+			want: nil,
+		},
+		{
+			name:    "dict without port",
+			body:    "d5:peersld2:ip9:192.0.2.1eee",
+			wantErr: true,
+		},
+		{
+			name:    "port out of range",
+			body:    "d5:peersld2:ip9:192.0.2.14:porti70000eeee",
+			wantErr: true,
+		},
+		{
+			name:    "peers of the wrong type",
+			body:    "d5:peersi1ee",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		resp, err := parseHTTPResponse([]byte(tt.body))
+
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: parseHTTPResponse() succeeded, want an error", tt.name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: parseHTTPResponse() error = %v", tt.name, err)
+			continue
+		}
+
+		if got := resp.peers(); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: peers = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParsePeersIgnoresTruncatedBlobs(t *testing.T) {
+	entry := []byte{192, 0, 2, 1, 0x1a, 0xe1}
+	entry6 := append(net.ParseIP("2001:db8::1"), 0x1a, 0xe1)
+
+	for extra := 0; extra < 6; extra++ {
+		blob := append(append([]byte(nil), entry...), entry[:extra]...)
+
+		if got := parsePeers(blob); !slices.Equal(got, []string{"192.0.2.1:6881"}) {
+			t.Errorf("parsePeers(%d bytes) = %v, want only the whole entry", len(blob), got)
+		}
+	}
+
+	for extra := 0; extra < 18; extra++ {
+		blob := append(append([]byte(nil), entry6...), entry6[:extra]...)
+
+		if got := parsePeers6(blob); !slices.Equal(got, []string{"[2001:db8::1]:6881"}) {
+			t.Errorf("parsePeers6(%d bytes) = %v, want only the whole entry", len(blob), got)
+		}
+	}
+
+	if got := parsePeers(entry[:5]); got != nil {
+		t.Errorf("parsePeers(5 bytes) = %v, want none", got)
+	}
+}
+
+func TestAnnounceReportsTrackerErrors(t *testing.T) {
+	failure, _ := decoder.Encode(map[string]any{"failure reason": "unregistered torrent"})
+	peers, _ := decoder.Encode(map[string]any{"interval": 60, "peers": string([]byte{192, 0, 2, 1, 0x1a, 0xe1})})
+
+	tests := []struct {
+		name    string
+		status  int
+		body    []byte
+		wantErr error
+		want    string
+	}{
+		{"failure reason", http.StatusOK, failure, ErrTrackerFailure, "unregistered torrent"},
+		{"failure reason with error status", http.StatusForbidden, failure, ErrTrackerFailure, "unregistered torrent"},
+		{"error status", http.StatusInternalServerError, []byte("oops"), nil, "500"},
+		{"error status with peers", http.StatusServiceUnavailable, peers, nil, "503"},
+	}
+
+	client := newTestClient(t, testData(1024), 1024)
+
+	for _, tt := range tests {
+		tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			w.Write(tt.body)
+		}))
+
+		_, err := client.announce(context.Background(), tracker.URL+"/announce", "")
+
+		tracker.Close()
+
+		if err == nil {
+			t.Errorf("%s: announce() succeeded", tt.name)
+			continue
+		}
+
+		if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: announce() error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: announce() error = %v, want it to mention %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestDownloadContextCancelsHungAnnounce(t *testing.T) {
+	release := make(chan struct{})
+
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer tracker.Close()
+	defer close(release)
+
+	path := writeTestTorrent(t, testTorrent(testData(1024), 1024), tracker.URL+"/announce")
+
+	client, err := NewTorrentClient(path, WithDHTBootstrap(nil))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	err = client.DownloadContext(ctx, filepath.Join(t.TempDir(), "out"))
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("DownloadContext() = %v, want context.DeadlineExceeded", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("DownloadContext() returned after %v with a hung tracker", elapsed)
+	}
+}
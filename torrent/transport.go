@@ -0,0 +1,61 @@
+package torrent
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/utp"
+)
+
+// dialPeer opens a stream to a peer over TCP, falling back to uTP when that
+// fails and the fallback is enabled. Either way the caller gets a net.Conn, so
+// the rest of the protocol code doesn't care which transport is underneath.
+func (client *TorrentClient) dialPeer(peerAddr string) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", peerAddr, client.dialTimeout)
+
+	if err == nil {
+		return client.withDeadlines(conn), nil
+	}
+
+	if !client.utpFallback {
+		return nil, err
+	}
+
+	utpConn, utpErr := utp.DialTimeout(peerAddr, client.dialTimeout)
+
+	if utpErr != nil {
+		return nil, fmt.Errorf("%v (utp fallback: %v)", err, utpErr)
+	}
+
+	return client.withDeadlines(utpConn), nil
+}
+
+// deadlineConn arms a fresh deadline before every read and write, so a peer
+// that stops responding fails the call instead of blocking it forever.
+type deadlineConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+// withDeadlines applies the client's read timeout to every read and write on
+// conn. A zero timeout leaves the connection unbounded.
+func (client *TorrentClient) withDeadlines(conn net.Conn) net.Conn {
+	if client.readTimeout <= 0 {
+		return conn
+	}
+
+	return &deadlineConn{Conn: conn, timeout: client.readTimeout}
+}
+
+func (c *deadlineConn) Read(b []byte) (int, error) {
+	c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+
+	return c.Conn.Read(b)
+}
+
+func (c *deadlineConn) Write(b []byte) (int, error) {
+	c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
+
+	return c.Conn.Write(b)
+}
@@ -0,0 +1,153 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+// BEP 15 constants.
+const (
+	udpProtocolID = 0x41727101980
+
+	udpActionConnect  = 0
+	udpActionAnnounce = 1
+	udpActionError    = 3
+
+	// The spec waits 15 * 2^n seconds for attempt n. We give up after a few
+	// attempts rather than the spec's eight so a dead tracker can't stall
+	// discovery for an hour.
+	udpTrackerTimeout = 15 * time.Second
+	udpTrackerRetries = 2
+)
+
+var udpEvents = map[string]uint32{
+	"":          0,
+	"completed": 1,
+	"started":   2,
+	"stopped":   3,
+}
+
+var errUDPTimeout = errors.New("udp tracker timed out")
+
+// announceUDP announces to a udp:// tracker: a connect exchange to obtain a
+// connection ID, then the announce itself.
+func (client *TorrentClient) announceUDP(tracker, event string) (*Response, error) {
+	u, err := url.Parse(tracker)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tracker url: %v", err)
+	}
+
+	conn, err := net.DialTimeout("udp", u.Host, client.dialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach udp tracker: %v", err)
+	}
+
+	defer conn.Close()
+
+	connect := make([]byte, 16)
+	binary.BigEndian.PutUint64(connect[0:], udpProtocolID)
+	binary.BigEndian.PutUint32(connect[8:], udpActionConnect)
+
+	reply, err := udpRoundTrip(conn, connect, udpActionConnect, 16)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to udp tracker: %v", err)
+	}
+
+	connectionID := binary.BigEndian.Uint64(reply[8:16])
+
+	var key [4]byte
+	rand.Read(key[:])
+
+	var request bytes.Buffer
+
+	binary.Write(&request, binary.BigEndian, connectionID)
+	binary.Write(&request, binary.BigEndian, uint32(udpActionAnnounce))
+	binary.Write(&request, binary.BigEndian, uint32(0)) // transaction id, set by udpRoundTrip
+	request.Write(client.InfoHash[:])
+	request.Write(client.PeerID[:])
+	binary.Write(&request, binary.BigEndian, client.downloaded.Load())
+	binary.Write(&request, binary.BigEndian, client.left())
+	binary.Write(&request, binary.BigEndian, client.uploaded.Load())
+	binary.Write(&request, binary.BigEndian, udpEvents[event])
+	binary.Write(&request, binary.BigEndian, uint32(0))
+	request.Write(key[:])
+	binary.Write(&request, binary.BigEndian, int32(-1))
+	binary.Write(&request, binary.BigEndian, uint16(client.Port()))
+
+	reply, err = udpRoundTrip(conn, request.Bytes(), udpActionAnnounce, 20)
+	if err != nil {
+		return nil, fmt.Errorf("failed to announce to udp tracker: %v", err)
+	}
+
+	response := &Response{
+		Interval:   int(binary.BigEndian.Uint32(reply[8:12])),
+		Incomplete: int(binary.BigEndian.Uint32(reply[12:16])),
+		Complete:   int(binary.BigEndian.Uint32(reply[16:20])),
+	}
+
+	// Trackers reached over IPv6 answer with 18-byte IPv6 peers.
+	if addr, ok := conn.RemoteAddr().(*net.UDPAddr); ok && addr.IP.To4() == nil {
+		response.Peers6 = string(reply[20:])
+	} else {
+		response.Peers = string(reply[20:])
+	}
+
+	return response, nil
+}
+
+// udpRoundTrip sends request with a fresh transaction ID (bytes 12:16 of a
+// BEP 15 request) and waits for the matching reply, retransmitting on
+// timeout. Replies for other transactions are ignored.
+func udpRoundTrip(conn net.Conn, request []byte, action uint32, minLength int) ([]byte, error) {
+	buf := make([]byte, 64*1024)
+
+	for attempt := 0; attempt <= udpTrackerRetries; attempt++ {
+		transactionID := make([]byte, 4)
+		rand.Read(transactionID)
+		copy(request[12:16], transactionID)
+
+		if _, err := conn.Write(request); err != nil {
+			return nil, err
+		}
+
+		conn.SetReadDeadline(time.Now().Add(udpTrackerTimeout << attempt))
+
+		for {
+			n, err := conn.Read(buf)
+
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				break
+			}
+
+			if err != nil {
+				return nil, err
+			}
+
+			reply := buf[:n]
+
+			if n < 8 || !bytes.Equal(reply[4:8], transactionID) {
+				continue
+			}
+
+			switch binary.BigEndian.Uint32(reply[0:4]) {
+			case udpActionError:
+				return nil, fmt.Errorf("tracker error: %s", reply[8:])
+			case action:
+				if n < minLength {
+					return nil, fmt.Errorf("reply too short (%d bytes)", n)
+				}
+
+				return append([]byte(nil), reply...), nil
+			}
+		}
+	}
+
+	return nil, errUDPTimeout
+}
@@ -0,0 +1,144 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrPieceHashMismatch = errors.New("piece hash mismatch")
+
+// PieceHash returns the SHA-1 digest of piece index, sliced straight out of
+// the pieces string so huge torrents don't hold a second copy of every hash.
+func (m MetaInfo) PieceHash(index int) ([20]byte, bool) {
+	var hash [20]byte
+
+	if index < 0 || index >= len(m.Pieces)/20 {
+		return hash, false
+	}
+
+	copy(hash[:], m.Pieces[index*20:(index+1)*20])
+
+	return hash, true
+}
+
+// PieceHashes splits the pieces string into one SHA-1 digest per piece. It
+// returns nil if the string isn't a whole number of digests. Prefer PieceHash
+// when only a few hashes are needed at a time.
+func (m MetaInfo) PieceHashes() [][20]byte {
+	if len(m.Pieces)%20 != 0 {
+		return nil
+	}
+
+	hashes := make([][20]byte, len(m.Pieces)/20)
+
+	for i := range hashes {
+		copy(hashes[i][:], m.Pieces[i*20:(i+1)*20])
+	}
+
+	return hashes
+}
+
+// validatePieces checks the piece geometry: a positive piece length and one
+// digest for every piece of the content. Call it after validateLayout so the
+// total length is sound.
+func (m MetaInfo) validatePieces() error {
+	if m.PieceLength <= 0 {
+		return fmt.Errorf("piece length %d is not positive", m.PieceLength)
+	}
+
+	if len(m.Pieces)%20 != 0 {
+		return fmt.Errorf("pieces length %d is not a multiple of 20", len(m.Pieces))
+	}
+
+	if hashes, pieces := len(m.Pieces)/20, m.pieceCount(); hashes != pieces {
+		return fmt.Errorf("pieces has %d hashes for %d pieces", hashes, pieces)
+	}
+
+	return nil
+}
+
+// pieceCount is how many pieces of PieceLength the content splits into.
+func (m MetaInfo) pieceCount() int {
+	if m.PieceLength <= 0 {
+		return 0
+	}
+
+	return int((int64(m.totalLength()) + m.PieceLength - 1) / m.PieceLength)
+}
+
+func (client *TorrentClient) pieceHash(index int) ([20]byte, error) {
+	hash, ok := client.File.Info.PieceHash(index)
+
+	if !ok {
+		return hash, fmt.Errorf("no hash for piece %d", index)
+	}
+
+	return hash, nil
+}
+
+func (client *TorrentClient) verifyPiece(index int, data []byte) error {
+	expected, err := client.pieceHash(index)
+
+	if err != nil {
+		return err
+	}
+
+	if sha1.Sum(data) != expected {
+		return fmt.Errorf("%w: piece %d", ErrPieceHashMismatch, index)
+	}
+
+	return nil
+}
+
+// MissingPieces reports which pieces of a (possibly partial) local copy are
+// absent or fail verification, along with how many bytes they account for.
+func (client *TorrentClient) MissingPieces(path string) ([]int, int64, error) {
+	file, err := client.openReader(path)
+
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open file: %v", err)
+	}
+
+	defer file.Close()
+
+	var missing []int
+
+	var missingBytes int64
+
+	for i := 0; i < client.pieceCount(); i++ {
+		ok, err := client.verifyFilePiece(file, i)
+
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if !ok {
+			missing = append(missing, i)
+			missingBytes += client.pieceSize(i)
+		}
+	}
+
+	return missing, missingBytes, nil
+}
+
+// verifyFilePiece reports whether piece index is present in file and matches
+// its hash. A file that ends before the piece does is not an error.
+func (client *TorrentClient) verifyFilePiece(file io.ReaderAt, index int) (bool, error) {
+	data := make([]byte, client.pieceSize(index))
+
+	_, err := file.ReadAt(data, int64(index)*client.File.Info.PieceLength)
+
+	if err == io.EOF {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("failed to read piece %d: %v", index, err)
+	}
+
+	client.metrics.AddCounter(MetricPiecesRehashed, 1)
+
+	return client.verifyPiece(index, data) == nil, nil
+}
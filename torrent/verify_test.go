@@ -0,0 +1,151 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/decoder"
+)
+
+func TestPieceHashes(t *testing.T) {
+	first := sha1.Sum([]byte("first"))
+	second := sha1.Sum([]byte("second"))
+
+	info := MetaInfo{Pieces: string(first[:]) + string(second[:])}
+
+	hashes := info.PieceHashes()
+
+	if len(hashes) != len(info.Pieces)/20 {
+		t.Fatalf("got %d hashes, want %d", len(hashes), len(info.Pieces)/20)
+	}
+
+	if hashes[0] != first || hashes[1] != second {
+		t.Fatalf("hashes don't match the pieces string")
+	}
+
+	if got := (MetaInfo{Pieces: "short"}).PieceHashes(); got != nil {
+		t.Fatalf("malformed pieces gave %d hashes, want nil", len(got))
+	}
+}
+
+func TestValidatePieces(t *testing.T) {
+	hashes := func(n int) string { return strings.Repeat("h", 20*n) }
+
+	tests := []struct {
+		name    string
+		info    MetaInfo
+		wantErr bool
+	}{
+		{"one hash per piece", MetaInfo{Length: 100, PieceLength: 40, Pieces: hashes(3)}, false},
+		{"exact multiple", MetaInfo{Length: 80, PieceLength: 40, Pieces: hashes(2)}, false},
+		{"zero piece length", MetaInfo{Length: 100, PieceLength: 0, Pieces: hashes(3)}, true},
+		{"negative piece length", MetaInfo{Length: 100, PieceLength: -1, Pieces: hashes(3)}, true},
+		{"too few hashes", MetaInfo{Length: 100, PieceLength: 40, Pieces: hashes(2)}, true},
+		{"too many hashes", MetaInfo{Length: 100, PieceLength: 40, Pieces: hashes(4)}, true},
+		{"partial hash", MetaInfo{Length: 100, PieceLength: 40, Pieces: hashes(3) + "x"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.info.validatePieces()
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validatePieces() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadRejectsZeroPieceLength(t *testing.T) {
+	data, err := decoder.Encode(map[string]any{
+		"announce": "http://tracker.invalid/announce",
+		"info": map[string]any{
+			"name":         "x",
+			"length":       10,
+			"piece length": 0,
+			"pieces":       strings.Repeat("h", 20),
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewTorrentClientFromReader(bytes.NewReader(data)); err == nil {
+		t.Fatal("loaded a torrent with piece length 0")
+	}
+}
+
+func TestSetInfoRejectsWrongHashCount(t *testing.T) {
+	rawInfo, err := decoder.Encode(map[string]any{
+		"name":         "x",
+		"length":       100,
+		"piece length": 40,
+		"pieces":       strings.Repeat("h", 40),
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (&TorrentClient{}).setInfo(rawInfo); err == nil {
+		t.Fatal("installed an info dict with 2 hashes for 3 pieces")
+	}
+}
+
+func TestPieceHash(t *testing.T) {
+	first := sha1.Sum([]byte("first"))
+	second := sha1.Sum([]byte("second"))
+
+	info := MetaInfo{Pieces: string(first[:]) + string(second[:])}
+
+	tests := []struct {
+		index  int
+		want   [20]byte
+		wantOK bool
+	}{
+		{0, first, true},
+		{1, second, true},
+		{2, [20]byte{}, false},
+		{-1, [20]byte{}, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := info.PieceHash(tt.index)
+
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("PieceHash(%d) = %x, %v, want %x, %v", tt.index, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+// benchmarkPieces is a pieces string for a torrent with 500,000 pieces.
+var benchmarkPieces = MetaInfo{Pieces: strings.Repeat("0123456789abcdefghij", 500_000)}
+
+// BenchmarkPieceHash walks every hash by index, which allocates nothing on
+// top of the pieces string.
+func BenchmarkPieceHash(b *testing.B) {
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		for index := 0; index < len(benchmarkPieces.Pieces)/20; index++ {
+			if _, ok := benchmarkPieces.PieceHash(index); !ok {
+				b.Fatal("missing hash")
+			}
+		}
+	}
+}
+
+// BenchmarkPieceHashes materializes every hash up front, duplicating the
+// pieces string, for comparison with BenchmarkPieceHash.
+func BenchmarkPieceHashes(b *testing.B) {
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		if hashes := benchmarkPieces.PieceHashes(); len(hashes) == 0 {
+			b.Fatal("missing hashes")
+		}
+	}
+}